@@ -2,8 +2,10 @@ package db
 
 import (
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"time"
 	"weldmart/models"
 
 	"gorm.io/driver/sqlite"
@@ -36,20 +38,41 @@ func InitDatabase() {
 	}
 
 	// Open the database (it will now exist or have been created)
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	// Transactions take the write lock when they begin and wait for each other instead of
+	// failing with "database is locked" when two of them try to write at the same time
+	DB, err = gorm.Open(sqlite.Open(dbPath+"?_txlock=immediate&_busy_timeout=5000"), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	log.Println("Database connected successfully at", dbPath)
 
+	Migrate()
+}
+
+// Migrate brings the schema of the open database up to date and fills in data that
+// older versions didn't store
+func Migrate() {
 	// Auto migrate the schema
 	DB.AutoMigrate(
 		&models.User{}, &models.Product{}, &models.Category{}, &models.Brand{},
 		&models.Banner{}, &models.News{}, &models.Achievement{}, &models.Rassika{},
 		&models.Order{}, &models.OrderItem{}, &models.HRassika{}, &models.Statistics{}, &models.Admin{}, &models.Clients{},
-		&models.PriceSwitch{},
+		&models.PriceSwitch{}, &models.RefreshToken{}, &models.OrderStatusHistory{}, &models.BonusTransaction{},
+		&models.StockReservation{}, &models.StockReservationItem{}, &models.IdempotencyKey{},
+		&models.SupportConversation{}, &models.SupportMessage{}, &models.SearchSynonym{},
 	)
 
+	hashPlainPasswords()
+	openBonusLedgers()
+	numberOrders()
+	snapshotOrderPrices()
+	// SQLite can't add a UNIQUE column to an existing table, so the index is created on its own
+	DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_number ON orders(number)")
+	initProductSearch()
+
+	// The admin used to be a single account; it becomes the owner under role-based access
+	DB.Model(&models.Admin{}).Where("role IS NULL OR role = ''").Update("role", models.RoleOwner)
+
 	// Check if PriceSwitch exists, if not create it
 	var priceSwitch models.PriceSwitch
 	result := DB.First(&priceSwitch)
@@ -63,3 +86,136 @@ func InitDatabase() {
 		log.Println("PriceSwitch created with default value: Show = true")
 	}
 }
+
+// hashPlainPasswords replaces passwords that were stored in plain text before hashing was introduced
+func hashPlainPasswords() {
+	var users []models.User
+	DB.Select("id", "password").Where("password <> ''").Find(&users)
+	for _, user := range users {
+		if models.IsPasswordHash(user.Password) {
+			continue
+		}
+		hash, err := models.HashPassword(user.Password)
+		if err != nil {
+			log.Println("Failed to hash password for user", user.ID, err)
+			continue
+		}
+		DB.Model(&models.User{}).Where("id = ?", user.ID).Update("password", hash)
+		log.Println("Hashed plain-text password for user", user.ID)
+	}
+
+	var admins []models.Admin
+	DB.Select("id", "password").Where("password <> ''").Find(&admins)
+	for _, admin := range admins {
+		if models.IsPasswordHash(admin.Password) {
+			continue
+		}
+		hash, err := models.HashPassword(admin.Password)
+		if err != nil {
+			log.Println("Failed to hash password for admin", admin.ID, err)
+			continue
+		}
+		DB.Model(&models.Admin{}).Where("id = ?", admin.ID).Update("password", hash)
+		log.Println("Hashed plain-text password for admin", admin.ID)
+	}
+}
+
+// openBonusLedgers records balances set before the bonus ledger existed as an opening
+// adjustment, so the balance derived from the ledger matches what users already had
+func openBonusLedgers() {
+	result := DB.Exec(`INSERT INTO bonus_transactions (user_id, type, amount, remaining, note, created_at)
+		SELECT id, ?, bonus, bonus, 'Opening balance', ? FROM users
+		WHERE bonus > 0 AND id NOT IN (SELECT user_id FROM bonus_transactions)`,
+		models.BonusAdjustment, time.Now())
+	if result.Error != nil {
+		log.Println("Failed to open bonus ledgers:", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Println("Opened bonus ledgers for", result.RowsAffected, "users")
+	}
+}
+
+// numberOrders gives orders placed before order numbers existed a number
+func numberOrders() {
+	var orders []models.Order
+	DB.Select("id", "created_at").Where("number IS NULL OR number = ''").Find(&orders)
+	for _, order := range orders {
+		number, err := models.GenerateOrderNumber(DB, order.CreatedAt)
+		if err != nil {
+			log.Println("Failed to number order", order.ID, err)
+			continue
+		}
+		DB.Model(&models.Order{}).Where("id = ?", order.ID).Update("number", number)
+	}
+	if len(orders) > 0 {
+		log.Println("Assigned order numbers to", len(orders), "orders")
+	}
+}
+
+// snapshotOrderPrices fills in the item prices and subtotal of orders placed before prices
+// were snapshotted. The order's price is what the customer was charged, so it is spread
+// over the lines in proportion to the current product prices, or evenly per unit when a
+// product is gone; an order without a price takes the product prices as they are.
+func snapshotOrderPrices() {
+	var orders []models.Order
+	DB.Preload("OrderItems.Product").
+		Where("subtotal = 0 AND id IN (SELECT order_id FROM order_items WHERE unit_price = 0 AND line_total = 0)").
+		Find(&orders)
+
+	priced := 0
+	for _, order := range orders {
+		units, listTotal, listed := 0, 0.0, true
+		for _, item := range order.OrderItems {
+			units += item.Quantity
+			listTotal += item.Product.Price * float64(item.Quantity)
+			listed = listed && item.Product.Price > 0
+		}
+		if units == 0 || (order.Price <= 0 && listTotal <= 0) {
+			continue
+		}
+
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			subtotal := 0.0
+			for _, item := range order.OrderItems {
+				unitPrice := item.Product.Price
+				switch {
+				case order.Price <= 0:
+				case listed:
+					unitPrice = item.Product.Price * order.Price / listTotal
+				default:
+					unitPrice = order.Price / float64(units)
+				}
+				unitPrice = roundMoney(unitPrice)
+				lineTotal := roundMoney(unitPrice * float64(item.Quantity))
+				subtotal += lineTotal
+				if err := tx.Model(&models.OrderItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+					"unit_price": unitPrice, "unit_discount": 0, "line_total": lineTotal,
+				}).Error; err != nil {
+					return err
+				}
+			}
+
+			// Rounding the unit prices may leave the lines a little above the price charged;
+			// the difference is shown as a discount so the totals still add up
+			subtotal = roundMoney(subtotal)
+			updates := map[string]interface{}{"subtotal": subtotal}
+			if order.Price <= 0 {
+				updates["price"] = math.Max(0, roundMoney(subtotal-order.Bonus))
+			} else if difference := roundMoney(subtotal - order.Bonus - order.Price); difference > 0 {
+				updates["discount_total"] = difference
+			}
+			return tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updates).Error
+		})
+		if err != nil {
+			log.Println("Failed to snapshot prices of order", order.ID, err)
+			continue
+		}
+		priced++
+	}
+	if priced > 0 {
+		log.Println("Snapshotted item prices of", priced, "orders")
+	}
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
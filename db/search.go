@@ -0,0 +1,121 @@
+package db
+
+import "log"
+
+// FullTextSearch reports whether the products_fts index is available. It needs SQLite
+// built with FTS5, which go-sqlite3 only includes with the sqlite_fts5 build tag (see the
+// Makefile). Without it product search falls back to slower, unranked LIKE queries.
+var FullTextSearch bool
+
+// productSearchColumns are the indexed columns of products_fts, in order
+const productSearchColumns = "name, description, info, feature, category, bottom_category, brand"
+
+// productSearchRow selects the indexed values of the product p
+const productSearchRow = `p.id, p.name, p.description, p.info, p.feature,
+	COALESCE((SELECT name FROM categories WHERE id = p.category_id), ''),
+	COALESCE((SELECT name FROM bottom_categories WHERE id = p.bottom_category_id), ''),
+	COALESCE((SELECT name FROM brands WHERE id = p.brand_id), '')`
+
+// productSearchTriggers keep products_fts in step with products and with the names of
+// the categories, bottom categories and brands they belong to
+var productSearchTriggers = map[string]string{
+	"products_fts_insert": `AFTER INSERT ON products BEGIN
+		INSERT INTO products_fts (rowid, ` + productSearchColumns + `)
+		SELECT ` + productSearchRow + ` FROM products p WHERE p.id = new.id;
+	END`,
+	"products_fts_update": `AFTER UPDATE OF name, description, info, feature, category_id, bottom_category_id, brand_id
+		ON products BEGIN
+		DELETE FROM products_fts WHERE rowid = old.id;
+		INSERT INTO products_fts (rowid, ` + productSearchColumns + `)
+		SELECT ` + productSearchRow + ` FROM products p WHERE p.id = new.id;
+	END`,
+	"products_fts_delete": `AFTER DELETE ON products BEGIN
+		DELETE FROM products_fts WHERE rowid = old.id;
+	END`,
+	"categories_fts_update": `AFTER UPDATE OF name ON categories BEGIN
+		UPDATE products_fts SET category = new.name
+		WHERE rowid IN (SELECT id FROM products WHERE category_id = new.id);
+	END`,
+	"categories_fts_delete": `AFTER DELETE ON categories BEGIN
+		UPDATE products_fts SET category = ''
+		WHERE rowid IN (SELECT id FROM products WHERE category_id = old.id);
+	END`,
+	"bottom_categories_fts_update": `AFTER UPDATE OF name ON bottom_categories BEGIN
+		UPDATE products_fts SET bottom_category = new.name
+		WHERE rowid IN (SELECT id FROM products WHERE bottom_category_id = new.id);
+	END`,
+	"bottom_categories_fts_delete": `AFTER DELETE ON bottom_categories BEGIN
+		UPDATE products_fts SET bottom_category = ''
+		WHERE rowid IN (SELECT id FROM products WHERE bottom_category_id = old.id);
+	END`,
+	"brands_fts_update": `AFTER UPDATE OF name ON brands BEGIN
+		UPDATE products_fts SET brand = new.name
+		WHERE rowid IN (SELECT id FROM products WHERE brand_id = new.id);
+	END`,
+	"brands_fts_delete": `AFTER DELETE ON brands BEGIN
+		UPDATE products_fts SET brand = ''
+		WHERE rowid IN (SELECT id FROM products WHERE brand_id = old.id);
+	END`,
+}
+
+// initProductSearch creates the products_fts index and its triggers, and fills the index
+// when it is out of step with the products table
+func initProductSearch() {
+	var fts5 bool
+	DB.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	if !fts5 {
+		// The triggers would make every product write fail on a build without FTS5
+		for name := range productSearchTriggers {
+			DB.Exec("DROP TRIGGER IF EXISTS " + name)
+		}
+		log.Println("Warning: SQLite was built without FTS5, product search falls back to LIKE queries; build with -tags sqlite_fts5 (make build)")
+		return
+	}
+
+	err := DB.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(` + productSearchColumns +
+		`, tokenize = 'unicode61 remove_diacritics 2')`).Error
+	if err != nil {
+		log.Println("Failed to create product search index:", err)
+		return
+	}
+
+	// Lists the indexed words, which queries are matched against for typos and transliteration
+	if err := DB.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS products_fts_vocab USING fts5vocab(products_fts, 'row')").Error; err != nil {
+		log.Println("Failed to create product search vocabulary:", err)
+		return
+	}
+
+	// Without the triggers the index may have missed product changes, so it is rebuilt
+	names := make([]string, 0, len(productSearchTriggers))
+	for name := range productSearchTriggers {
+		names = append(names, name)
+	}
+	var triggers int64
+	DB.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ?", names).Scan(&triggers)
+	stale := triggers < int64(len(productSearchTriggers))
+
+	for name, body := range productSearchTriggers {
+		if err := DB.Exec("CREATE TRIGGER IF NOT EXISTS " + name + " " + body).Error; err != nil {
+			log.Println("Failed to create trigger", name, err)
+			return
+		}
+	}
+
+	var indexed, products int64
+	DB.Raw("SELECT count(*) FROM products_fts").Scan(&indexed)
+	DB.Raw("SELECT count(*) FROM products").Scan(&products)
+	if stale || indexed != products {
+		err := DB.Exec("DELETE FROM products_fts").Error
+		if err == nil {
+			err = DB.Exec(`INSERT INTO products_fts (rowid, ` + productSearchColumns + `)
+				SELECT ` + productSearchRow + ` FROM products p`).Error
+		}
+		if err != nil {
+			log.Println("Failed to build product search index:", err)
+			return
+		}
+		log.Println("Indexed", products, "products for search")
+	}
+
+	FullTextSearch = true
+}
@@ -1,3 +1,5 @@
+// The WeldMart API server. Ranked product search needs SQLite with FTS5, so release
+// builds use the sqlite_fts5 tag; "make build" and "make test" pass it. See README.md.
 package main
 
 import (
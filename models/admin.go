@@ -2,11 +2,57 @@ package models
 
 import "time"
 
+// Admin roles
+const (
+	RoleOwner          = "owner"
+	RoleCatalogManager = "catalog_manager"
+	RoleOrderManager   = "order_manager"
+	RoleContentEditor  = "content_editor"
+)
+
+// Admin permissions, each guarding a group of routes
+const (
+	PermissionCatalog     = "catalog" // Products, categories, bottom categories and brands
+	PermissionOrders      = "orders"
+	PermissionContent     = "content" // Banners, news and other site content
+	PermissionPriceSwitch = "price_switch"
+	PermissionUsers       = "users"
+	PermissionAdmins      = "admins"
+)
+
+// RolePermissions lists what each role is allowed to do
+var RolePermissions = map[string][]string{
+	RoleOwner: {
+		PermissionCatalog, PermissionOrders, PermissionContent,
+		PermissionPriceSwitch, PermissionUsers, PermissionAdmins,
+	},
+	RoleCatalogManager: {PermissionCatalog, PermissionPriceSwitch},
+	RoleOrderManager:   {PermissionOrders, PermissionUsers},
+	RoleContentEditor:  {PermissionContent},
+}
+
 type Admin struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Name      string    `gorm:"not null" json:"name"`
 	Login     string    `gorm:"unique;not null" json:"login"`
-	Password  string    `gorm:"not null" json:"password"`
+	Password  string    `gorm:"not null" json:"-"`
+	Role      string    `gorm:"default:null" json:"role"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
+
+// IsValidRole reports whether role is one of the known admin roles
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// Can reports whether the admin's role grants the permission
+func (a *Admin) Can(permission string) bool {
+	for _, p := range RolePermissions[a.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Bonus transaction types
+const (
+	BonusAccrual    = "accrual"    // Earned for a delivered order
+	BonusRedemption = "redemption" // Spent at checkout
+	BonusRefund     = "refund"     // Redeemed points given back when an order is cancelled or returned
+	BonusReversal   = "reversal"   // Accrual taken back when a delivered order is returned
+	BonusExpiration = "expiration"
+	BonusAdjustment = "adjustment" // Manual correction by an admin
+)
+
+// BonusTransaction is one entry of a user's bonus ledger. Credits have a positive amount
+// and track how much of them is still unspent in Remaining; debits are negative and
+// consume the oldest credits first.
+type BonusTransaction struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	OrderID   *uint      `gorm:"index" json:"order_id,omitempty"`
+	AdminID   *uint      `json:"admin_id,omitempty"`
+	Type      string     `gorm:"not null" json:"type"`
+	Amount    float64    `gorm:"not null" json:"amount"`
+	Remaining float64    `gorm:"not null;default:0" json:"remaining"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyKey stores the first response to a request sent with an Idempotency-Key
+// header so retries of the same request get the same answer instead of repeating it.
+// CompletedAt stays nil while the first request is still being handled.
+type IdempotencyKey struct {
+	ID           uint   `gorm:"primaryKey"`
+	Key          string `gorm:"uniqueIndex:idx_idempotency_key_scope;not null"`
+	Scope        string `gorm:"uniqueIndex:idx_idempotency_key_scope;not null"` // Route and caller the key belongs to
+	RequestHash  string `gorm:"not null"`
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+	CompletedAt  *time.Time
+	ExpiresAt    time.Time `gorm:"index"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Order history events
+const (
+	OrderEventStatus = "status" // The order moved to another status
+	OrderEventItems  = "items"  // Items were added, removed or changed; the note lists the changes
+)
+
+// OrderStatusHistory records every status change and item edit of an order and who made it
+type OrderStatusHistory struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	OrderID       uint      `gorm:"index;not null" json:"order_id"`
+	Event         string    `gorm:"not null;default:status" json:"event"`
+	FromStatus    string    `json:"from_status"`
+	ToStatus      string    `gorm:"not null" json:"to_status"`
+	ChangedByType string    `gorm:"not null" json:"changed_by_type"` // "admin", "user", "guest" or "system"
+	ChangedByID   uint      `json:"changed_by_id,omitempty"`
+	ChangedByName string    `json:"changed_by_name,omitempty"`
+	Note          string    `json:"note,omitempty"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
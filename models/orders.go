@@ -1,33 +1,126 @@
 package models
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Order struct {
-	ID           uint        `gorm:"primaryKey" json:"id"`
-	Price        float64     `json:"price" validate:"required"`
-	Bonus        float64     `json:"bonus"`
-	UserID       uint        `json:"user_id"`
-	OrderType    string      `gorm:"column:order_type" json:"order_type"`
-	Status       string      `json:"status"`
-	Phone        string      `json:"phone,omitempty" gorm:"default:null"`
-	Name         string      `json:"name,omitempty" gorm:"default:null"`
-	Service      string      `json:"service_mode" gorm:"default:null"`
-	Organization string      `json:"organization,omitempty" gorm:"default:null"`
-	INN          string      `json:"inn,omitempty" gorm:"default:null"`
-	Comment      string      `json:"comment,omitempty" gorm:"default:null"`
-	CreatedAt    time.Time   `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt    time.Time   `gorm:"autoUpdateTime" json:"updated_at"`
-	OrderItems   []OrderItem `gorm:"foreignKey:OrderID" json:"order_items"`
+	ID            uint                 `gorm:"primaryKey" json:"id"`
+	Number        string               `gorm:"default:null" json:"number"` // Unique index created in db.InitDatabase
+	Price         float64              `json:"price" validate:"required"`  // Amount to pay: subtotal less discounts and redeemed bonus
+	Subtotal      float64              `json:"subtotal"`
+	DiscountTotal float64              `json:"discount_total"`
+	Bonus         float64              `json:"bonus"`
+	UserID        uint                 `json:"user_id"`
+	OrderType     string               `gorm:"column:order_type" json:"order_type"`
+	Status        string               `json:"status"`
+	Phone         string               `json:"phone,omitempty" gorm:"default:null"`
+	Name          string               `json:"name,omitempty" gorm:"default:null"`
+	Service       string               `json:"service_mode" gorm:"default:null"`
+	Organization  string               `json:"organization,omitempty" gorm:"default:null"`
+	INN           string               `json:"inn,omitempty" gorm:"default:null"`
+	Comment       string               `json:"comment,omitempty" gorm:"default:null"`
+	CreatedAt     time.Time            `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time            `gorm:"autoUpdateTime" json:"updated_at"`
+	RestockedAt   *time.Time           `json:"restocked_at,omitempty"` // Set once the items were returned to stock
+	OrderItems    []OrderItem          `gorm:"foreignKey:OrderID" json:"order_items"`
+	StatusHistory []OrderStatusHistory `gorm:"foreignKey:OrderID" json:"status_history,omitempty"`
 }
 
 type OrderItem struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	OrderID   uint      `json:"order_id"`
-	ProductID uint      `json:"product_id"`
-	Quantity  int       `json:"quantity" validate:"required,min=1"`
-	Product   Product   `gorm:"foreignKey:ProductID" json:"product,omitempty"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	OrderID      uint      `json:"order_id"`
+	ProductID    uint      `json:"product_id"`
+	Quantity     int       `json:"quantity" validate:"required,min=1"`
+	UnitPrice    float64   `json:"unit_price"` // Snapshot at ordering time, later product edits don't change it
+	UnitDiscount float64   `json:"unit_discount"`
+	LineTotal    float64   `json:"line_total"`
+	Product      Product   `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Order statuses
+const (
+	OrderStatusNew       = "new"
+	OrderStatusConfirmed = "confirmed"
+	OrderStatusPacked    = "packed"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusReturned  = "returned"
+)
+
+// OrderStatusTransitions lists the statuses an order may move to from each status
+var OrderStatusTransitions = map[string][]string{
+	OrderStatusNew:       {OrderStatusConfirmed, OrderStatusCancelled},
+	OrderStatusConfirmed: {OrderStatusPacked, OrderStatusCancelled},
+	OrderStatusPacked:    {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:   {OrderStatusDelivered, OrderStatusReturned},
+	OrderStatusDelivered: {OrderStatusReturned},
+	OrderStatusCancelled: {},
+	OrderStatusReturned:  {},
+}
+
+// OrderStatusRestocks reports whether reaching the status puts the order's items back in stock
+func OrderStatusRestocks(status string) bool {
+	return status == OrderStatusCancelled || status == OrderStatusReturned
+}
+
+// OrderItemsEditable reports whether the items of an order in the given status may still
+// be changed: only until the order ships. Legacy free-form statuses count as new.
+func OrderItemsEditable(status string) bool {
+	switch status {
+	case OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled, OrderStatusReturned:
+		return false
+	}
+	return true
+}
+
+// AllowedOrderStatuses returns the statuses reachable from the given one. Orders created
+// before the lifecycle existed carry free-form statuses and are treated as new.
+func AllowedOrderStatuses(from string) []string {
+	allowed, known := OrderStatusTransitions[from]
+	if !known {
+		return OrderStatusTransitions[OrderStatusNew]
+	}
+	return allowed
+}
+
+// CanTransitionOrderStatus reports whether an order may move from one status to another
+func CanTransitionOrderStatus(from, to string) bool {
+	for _, status := range AllowedOrderStatuses(from) {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateOrderNumber returns an unused public order number such as WM-2026-000123 for an
+// order placed at the given time. The serial part is random so numbers don't reveal how
+// many orders were placed.
+func GenerateOrderNumber(tx *gorm.DB, placedAt time.Time) (string, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		serial, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", err
+		}
+		number := fmt.Sprintf("WM-%d-%06d", placedAt.Year(), serial.Int64())
+
+		var count int64
+		if err := tx.Model(&Order{}).Where("number = ?", number).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return number, nil
+		}
+	}
+	return "", errors.New("could not find a free order number")
 }
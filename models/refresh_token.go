@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RefreshToken tracks issued refresh tokens so they can be rotated and revoked.
+// Kind tells whether SubjectID refers to a customer ("user") or an admin ("admin").
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	TokenID   string     `gorm:"uniqueIndex;not null" json:"-"`
+	Kind      string     `gorm:"not null;default:user" json:"kind"`
+	SubjectID uint       `gorm:"index;not null" json:"subject_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
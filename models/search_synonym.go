@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// SearchSynonym is a group of words product search treats as the same, e.g. "payvand" and
+// "svarka". A query word matching the term or any synonym matches all of them.
+type SearchSynonym struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Term      string    `gorm:"uniqueIndex;not null" json:"term"`
+	Synonyms  []string  `gorm:"type:text;serializer:json" json:"synonyms"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
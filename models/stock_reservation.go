@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// StockReservation holds products out of stock for a limited time while the customer
+// pays. The stock is taken when the reservation is made and given back if it is released
+// or expires before an order consumes it.
+type StockReservation struct {
+	ID         uint                   `gorm:"primaryKey" json:"-"`
+	Token      string                 `gorm:"uniqueIndex;not null" json:"reservation_id"`
+	UserID     uint                   `gorm:"index" json:"user_id,omitempty"`
+	OrderID    *uint                  `json:"order_id,omitempty"`
+	ExpiresAt  time.Time              `gorm:"index" json:"expires_at"`
+	ConsumedAt *time.Time             `json:"consumed_at,omitempty"`
+	ReleasedAt *time.Time             `json:"released_at,omitempty"`
+	CreatedAt  time.Time              `gorm:"autoCreateTime" json:"created_at"`
+	Items      []StockReservationItem `gorm:"foreignKey:ReservationID" json:"items"`
+}
+
+type StockReservationItem struct {
+	ID            uint `gorm:"primaryKey" json:"-"`
+	ReservationID uint `gorm:"index" json:"-"`
+	ProductID     uint `json:"product_id"`
+	Quantity      int  `json:"quantity"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Support message authors
+const (
+	SupportSenderCustomer = "customer"
+	SupportSenderAdmin    = "admin"
+)
+
+// SupportConversation is the support chat of one customer: a registered user, or a guest
+// who left a name and phone and holds the guest token. Read receipts are kept as the last
+// message each side has read.
+type SupportConversation struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	UserID             *uint      `gorm:"uniqueIndex" json:"user_id,omitempty"`
+	GuestToken         string     `gorm:"uniqueIndex;default:null" json:"-"`
+	Name               string     `json:"name"`
+	Phone              string     `json:"phone"`
+	CustomerLastReadID uint       `json:"customer_last_read_id"`
+	AdminLastReadID    uint       `json:"admin_last_read_id"`
+	LastMessageAt      *time.Time `gorm:"index" json:"last_message_at,omitempty"`
+	CreatedAt          time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type SupportMessage struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ConversationID uint      `gorm:"index;not null" json:"conversation_id"`
+	Sender         string    `gorm:"not null" json:"sender"` // customer or admin
+	AdminID        *uint     `json:"admin_id,omitempty"`
+	AuthorName     string    `json:"author_name"`
+	Text           string    `gorm:"not null" json:"text"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
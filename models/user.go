@@ -6,10 +6,10 @@ type User struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Name      string    `json:"name" gorm:"default:null"`
 	Phone     string    `gorm:"unique" json:"phone"`
-	Password  string    `json:"password"`
-	Bonus     float64   `json:"bonus" gorm:"default:null"`
+	Password  string    `json:"-"`
+	Bonus     float64   `json:"bonus" gorm:"default:null"` // Balance derived from the bonus ledger
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 	RassikaID uint      `json:"rassika_id" gorm:"default:null"`
-	Orders    []Order   `gorm:"foreignKey:UserID; default:null" json:"orders"`
+	Orders    []Order   `gorm:"foreignKey:UserID; default:null" json:"orders,omitempty"`
 }
\ No newline at end of file
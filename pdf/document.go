@@ -0,0 +1,218 @@
+// Package pdf writes simple text-and-line PDF documents with embedded TrueType fonts.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Document is a PDF being built page by page. Coordinates are in points measured from
+// the top-left corner of the page.
+type Document struct {
+	pages []*Page
+	fonts []*fontUse
+}
+
+// fontUse tracks an embedded font and the glyphs drawn with it
+type fontUse struct {
+	font *Font
+	ref  string
+	used map[uint16]rune
+}
+
+// Page is one page of a document
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+}
+
+// New returns an empty document
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage appends a blank page and returns it
+func (d *Document) AddPage() *Page {
+	page := &Page{doc: d}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+func (d *Document) use(font *Font) *fontUse {
+	for _, fu := range d.fonts {
+		if fu.font == font {
+			return fu
+		}
+	}
+	fu := &fontUse{font: font, ref: fmt.Sprintf("F%d", len(d.fonts)+1), used: make(map[uint16]rune)}
+	d.fonts = append(d.fonts, fu)
+	return fu
+}
+
+// Text draws s with its baseline starting at (x, y)
+func (p *Page) Text(font *Font, size, x, y float64, s string) {
+	fu := p.doc.use(font)
+	var glyphs strings.Builder
+	for _, r := range s {
+		gid := font.Glyph(r)
+		if _, seen := fu.used[gid]; !seen {
+			fu.used[gid] = r
+		}
+		fmt.Fprintf(&glyphs, "%04X", gid)
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.2f Tf %.2f %.2f Td <%s> Tj ET\n", fu.ref, size, x, PageHeight-y, glyphs.String())
+}
+
+// TextRight draws s so that it ends at x
+func (p *Page) TextRight(font *Font, size, x, y float64, s string) {
+	p.Text(font, size, x-font.TextWidth(s, size), y, s)
+}
+
+// Line draws a straight line
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Rect draws the outline of a rectangle whose top-left corner is (x, y)
+func (p *Page) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f %.2f %.2f re S\n", width, x, PageHeight-y-h, w, h)
+}
+
+// pdfWriter writes numbered objects and remembers their offsets for the xref table
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (w *pdfWriter) object(id int, body string) {
+	for len(w.offsets) < id {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *pdfWriter) stream(id int, dict string, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+	body := fmt.Sprintf("<< %s /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", dict, compressed.Len(), compressed.Bytes())
+	w.object(id, body)
+}
+
+// WriteTo renders the document
+func (d *Document) WriteTo(out io.Writer) (int64, error) {
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects: 1 catalog, 2 page tree, then two per page, then five per font
+	const catalogID, pagesID = 1, 2
+	pageID := func(i int) int { return 3 + 2*i }
+	fontBase := 3 + 2*len(d.pages)
+	fontID := func(i int) int { return fontBase + 5*i }
+
+	var fonts strings.Builder
+	for i, fu := range d.fonts {
+		fmt.Fprintf(&fonts, "/%s %d 0 R ", fu.ref, fontID(i))
+	}
+
+	var kids strings.Builder
+	for i := range d.pages {
+		fmt.Fprintf(&kids, "%d 0 R ", pageID(i))
+	}
+	w.object(catalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+	w.object(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(d.pages)))
+
+	for i, page := range d.pages {
+		w.object(pageID(i), fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s>> >> /Contents %d 0 R >>",
+			pagesID, PageWidth, PageHeight, fonts.String(), pageID(i)+1))
+		w.stream(pageID(i)+1, "", page.content.Bytes())
+	}
+
+	for i, fu := range d.fonts {
+		writeFont(w, fontID(i), fu)
+	}
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, catalogID, xref)
+
+	n, err := out.Write(w.buf.Bytes())
+	return int64(n), err
+}
+
+// Bytes renders the document into memory
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// writeFont embeds a font as a Type0 font with Identity-H encoding (text is written as
+// glyph IDs) and a ToUnicode map so text can be searched and copied from the document
+func writeFont(w *pdfWriter, id int, fu *fontUse) {
+	f := fu.font
+	cidFontID, descriptorID, fileID, toUnicodeID := id+1, id+2, id+3, id+4
+
+	gids := make([]int, 0, len(fu.used))
+	for gid := range fu.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", gid, f.glyphWidth(uint16(gid)))
+	}
+
+	w.object(id, fmt.Sprintf(
+		"<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		f.name, cidFontID, toUnicodeID))
+	w.object(cidFontID, fmt.Sprintf(
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /W [%s] >>",
+		f.name, descriptorID, widths.String()))
+	w.object(descriptorID, fmt.Sprintf(
+		"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		f.name, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]),
+		f.scale(f.ascent), f.scale(f.descent), f.scale(f.ascent), fileID))
+	w.stream(fileID, fmt.Sprintf("/Length1 %d", len(f.data)), f.data)
+
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	cmap.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	cmap.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	cmap.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		end := start + 100
+		if end > len(gids) {
+			end = len(gids)
+		}
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			var unicode strings.Builder
+			for _, unit := range utf16.Encode([]rune{fu.used[uint16(gid)]}) {
+				fmt.Fprintf(&unicode, "%04X", unit)
+			}
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", gid, unicode.String())
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	w.stream(toUnicodeID, "", []byte(cmap.String()))
+}
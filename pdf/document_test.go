@@ -0,0 +1,134 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	trailerPattern = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R >>\nstartxref\n(\d+)\n%%EOF\n$`)
+	lengthPattern  = regexp.MustCompile(`/Length (\d+) >>\nstream\n`)
+)
+
+// parseDocument follows the trailer and xref table of a rendered document to each object
+// and returns the object bodies, with streams checked against their length and inflated
+func parseDocument(t *testing.T, data []byte) map[int]string {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatalf("document starts with %q", data[:min(len(data), 16)])
+	}
+	trailer := trailerPattern.FindSubmatch(data)
+	if trailer == nil {
+		t.Fatal("document doesn't end with a trailer")
+	}
+	size, _ := strconv.Atoi(string(trailer[1]))
+	xref, _ := strconv.Atoi(string(trailer[2]))
+	header := fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", size)
+	if xref >= len(data) || !bytes.HasPrefix(data[xref:], []byte(header)) {
+		t.Fatalf("startxref %d doesn't point at an xref table of %d entries", xref, size)
+	}
+	entries := data[xref+len(header):]
+
+	objects := map[int]string{}
+	for id := 1; id < size; id++ {
+		var offset int
+		if _, err := fmt.Sscanf(string(entries[(id-1)*20:id*20]), "%010d 00000 n \n", &offset); err != nil {
+			t.Fatalf("xref entry for object %d: %v", id, err)
+		}
+		prefix := fmt.Sprintf("%d 0 obj\n", id)
+		if offset >= xref || !bytes.HasPrefix(data[offset:], []byte(prefix)) {
+			t.Fatalf("object %d isn't at offset %d", id, offset)
+		}
+		body := data[offset+len(prefix):]
+
+		if match := lengthPattern.FindSubmatchIndex(body); match != nil && bytes.HasPrefix(body, []byte("<<")) &&
+			!bytes.Contains(body[:match[0]], []byte("endobj")) {
+			length, _ := strconv.Atoi(string(body[match[2]:match[3]]))
+			stream := body[match[1]:]
+			if len(stream) < length || !bytes.HasPrefix(stream[length:], []byte("\nendstream\nendobj\n")) {
+				t.Fatalf("object %d: stream doesn't end after its /Length of %d bytes", id, length)
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(stream[:length]))
+			if err != nil {
+				t.Fatalf("object %d: %v", id, err)
+			}
+			inflated, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("object %d: %v", id, err)
+			}
+			objects[id] = string(body[:match[1]]) + string(inflated)
+			continue
+		}
+
+		end := bytes.Index(body, []byte("\nendobj\n"))
+		if end < 0 {
+			t.Fatalf("object %d has no end", id)
+		}
+		objects[id] = string(body[:end])
+	}
+	return objects
+}
+
+func TestDocumentStructure(t *testing.T) {
+	doc := New()
+	page := doc.AddPage()
+	page.Line(40, 60, 555, 60, 1)
+	page.Rect(40, 80, 100, 20, 0.5)
+	doc.AddPage()
+
+	objects := parseDocument(t, doc.Bytes())
+	if !strings.Contains(objects[1], "/Type /Catalog /Pages 2 0 R") {
+		t.Errorf("catalog is %q", objects[1])
+	}
+	if !strings.Contains(objects[2], "/Kids [3 0 R 5 0 R ] /Count 2") {
+		t.Errorf("page tree is %q", objects[2])
+	}
+	if !strings.Contains(objects[4], "1.00 w 40.00 781.89 m 555.00 781.89 l S") {
+		t.Errorf("first page content is %q", objects[4])
+	}
+	if !strings.Contains(objects[4], "0.50 w 40.00 741.89 100.00 20.00 re S") {
+		t.Errorf("first page content is %q", objects[4])
+	}
+}
+
+func TestDocumentText(t *testing.T) {
+	font := loadTestFont(t)
+	doc := New()
+	page := doc.AddPage()
+	page.Text(font, 12, 40, 60, "Счёт №1")
+	page.TextRight(font, 10, 555, 80, "Итого")
+
+	objects := parseDocument(t, doc.Bytes())
+
+	var glyphs strings.Builder
+	for _, r := range "Счёт №1" {
+		fmt.Fprintf(&glyphs, "%04X", font.Glyph(r))
+	}
+	if want := "BT /F1 12.00 Tf 40.00 781.89 Td <" + glyphs.String() + "> Tj ET"; !strings.Contains(objects[4], want) {
+		t.Errorf("page content %q lacks %q", objects[4], want)
+	}
+	right := fmt.Sprintf("%.2f 761.89 Td", 555-font.TextWidth("Итого", 10))
+	if !strings.Contains(objects[4], right) {
+		t.Errorf("page content %q lacks right-aligned text at %q", objects[4], right)
+	}
+
+	// One font: Type0 font, CID font, descriptor, font file and ToUnicode map
+	if !strings.Contains(objects[5], "/Subtype /Type0") || !strings.Contains(objects[5], "/ToUnicode 9 0 R") {
+		t.Errorf("font object is %q", objects[5])
+	}
+	if !strings.HasPrefix(objects[8], "<< /Length1 "+strconv.Itoa(len(font.data))) || !strings.HasSuffix(objects[8], string(font.data)) {
+		t.Error("font file isn't the embedded font")
+	}
+	for _, r := range "Счёт№1Итого" {
+		mapping := fmt.Sprintf("<%04X> <%04X>", font.Glyph(r), r)
+		if !strings.Contains(objects[9], mapping) {
+			t.Errorf("ToUnicode map lacks %s for %q", mapping, r)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Font is a TrueType font that can be embedded in a document. Text is drawn by glyph ID,
+// so any script the font covers (Cyrillic included) renders correctly.
+type Font struct {
+	name       string
+	data       []byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	glyphs     map[rune]uint16
+	advances   []uint16
+}
+
+// LoadFont reads and parses a TrueType (.ttf) font file
+func LoadFont(path string) (*Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return ParseFont(strings.ReplaceAll(name, " ", ""), data)
+}
+
+// ParseFont parses TrueType font data
+func ParseFont(name string, data []byte) (*Font, error) {
+	tables, err := readTableDirectory(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap"} {
+		if _, ok := tables[tag]; !ok {
+			return nil, fmt.Errorf("pdf: font has no %s table", tag)
+		}
+	}
+
+	f := &Font{name: name, data: data}
+
+	head := tables["head"]
+	if len(head) < 54 {
+		return nil, errors.New("pdf: head table too short")
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("pdf: font has no units per em")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+
+	hhea := tables["hhea"]
+	if len(hhea) < 36 {
+		return nil, errors.New("pdf: hhea table too short")
+	}
+	f.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+
+	maxp := tables["maxp"]
+	if len(maxp) < 6 {
+		return nil, errors.New("pdf: maxp table too short")
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+
+	hmtx := tables["hmtx"]
+	if numHMetrics == 0 || len(hmtx) < 4*numHMetrics {
+		return nil, errors.New("pdf: hmtx table too short")
+	}
+	f.advances = make([]uint16, numGlyphs)
+	for gid := range f.advances {
+		metric := gid
+		if metric >= numHMetrics {
+			metric = numHMetrics - 1
+		}
+		f.advances[gid] = binary.BigEndian.Uint16(hmtx[4*metric:])
+	}
+
+	if f.glyphs, err = readCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Glyph returns the glyph ID drawn for r, 0 (the missing glyph) if the font lacks it
+func (f *Font) Glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+// glyphWidth returns the advance of a glyph in thousandths of the font size
+func (f *Font) glyphWidth(gid uint16) int {
+	if int(gid) >= len(f.advances) {
+		return 0
+	}
+	return int(f.advances[gid]) * 1000 / f.unitsPerEm
+}
+
+// TextWidth returns the width of s drawn at the given size, in points
+func (f *Font) TextWidth(s string, size float64) float64 {
+	var width int
+	for _, r := range s {
+		width += f.glyphWidth(f.Glyph(r))
+	}
+	return float64(width) * size / 1000
+}
+
+// scale converts font units to thousandths of the font size
+func (f *Font) scale(v int) int {
+	return v * 1000 / f.unitsPerEm
+}
+
+func readTableDirectory(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("pdf: not a TrueType font")
+	}
+	version := binary.BigEndian.Uint32(data)
+	if version != 0x00010000 && version != 0x74727565 { // 1.0 or "true"
+		return nil, errors.New("pdf: only TrueType outlines are supported")
+	}
+	count := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*count {
+		return nil, errors.New("pdf: truncated table directory")
+	}
+	tables := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		record := data[12+16*i:]
+		offset := binary.BigEndian.Uint32(record[8:])
+		length := binary.BigEndian.Uint32(record[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("pdf: table %s out of bounds", record[:4])
+		}
+		tables[string(record[:4])] = data[offset : offset+length]
+	}
+	return tables, nil
+}
+
+// readCmap maps characters to glyphs from the best Unicode subtable: format 12 (full
+// Unicode) when present, format 4 (Basic Multilingual Plane) otherwise
+func readCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errors.New("pdf: cmap table too short")
+	}
+	var format4, format12 []byte
+	count := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < count && 4+8*i+8 <= len(cmap); i++ {
+		record := cmap[4+8*i:]
+		platform := binary.BigEndian.Uint16(record)
+		encoding := binary.BigEndian.Uint16(record[2:])
+		offset := binary.BigEndian.Uint32(record[4:])
+		if int(offset)+2 > len(cmap) {
+			continue
+		}
+		unicode := platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))
+		if !unicode {
+			continue
+		}
+		switch binary.BigEndian.Uint16(cmap[offset:]) {
+		case 4:
+			format4 = cmap[offset:]
+		case 12:
+			format12 = cmap[offset:]
+		}
+	}
+
+	switch {
+	case format12 != nil:
+		return readCmap12(format12)
+	case format4 != nil:
+		return readCmap4(format4)
+	}
+	return nil, errors.New("pdf: font has no Unicode cmap")
+}
+
+func readCmap4(table []byte) (map[rune]uint16, error) {
+	if len(table) < 14 {
+		return nil, errors.New("pdf: cmap format 4 too short")
+	}
+	segments := int(binary.BigEndian.Uint16(table[6:])) / 2
+	endCodes := 14
+	startCodes := endCodes + 2*segments + 2
+	deltas := startCodes + 2*segments
+	rangeOffsets := deltas + 2*segments
+	if len(table) < rangeOffsets+2*segments {
+		return nil, errors.New("pdf: cmap format 4 truncated")
+	}
+
+	glyphs := make(map[rune]uint16)
+	for i := 0; i < segments; i++ {
+		end := int(binary.BigEndian.Uint16(table[endCodes+2*i:]))
+		start := int(binary.BigEndian.Uint16(table[startCodes+2*i:]))
+		delta := binary.BigEndian.Uint16(table[deltas+2*i:])
+		rangeOffsetPos := rangeOffsets + 2*i
+		rangeOffset := int(binary.BigEndian.Uint16(table[rangeOffsetPos:]))
+		for c := start; c <= end && c != 0xFFFF; c++ {
+			var gid uint16
+			if rangeOffset == 0 {
+				gid = uint16(c) + delta
+			} else {
+				pos := rangeOffsetPos + rangeOffset + 2*(c-start)
+				if pos+2 > len(table) {
+					continue
+				}
+				gid = binary.BigEndian.Uint16(table[pos:])
+				if gid != 0 {
+					gid += delta
+				}
+			}
+			if gid != 0 {
+				glyphs[rune(c)] = gid
+			}
+		}
+	}
+	return glyphs, nil
+}
+
+func readCmap12(table []byte) (map[rune]uint16, error) {
+	if len(table) < 16 {
+		return nil, errors.New("pdf: cmap format 12 too short")
+	}
+	groups := int(binary.BigEndian.Uint32(table[12:]))
+	if len(table) < 16+12*groups {
+		return nil, errors.New("pdf: cmap format 12 truncated")
+	}
+	glyphs := make(map[rune]uint16)
+	for i := 0; i < groups; i++ {
+		group := table[16+12*i:]
+		start := binary.BigEndian.Uint32(group)
+		end := binary.BigEndian.Uint32(group[4:])
+		gid := binary.BigEndian.Uint32(group[8:])
+		for c := start; c <= end && c <= 0x10FFFF; c++ {
+			glyphs[rune(c)] = uint16(gid + c - start)
+		}
+	}
+	return glyphs, nil
+}
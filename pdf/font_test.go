@@ -0,0 +1,71 @@
+package pdf
+
+import (
+	"os"
+	"testing"
+)
+
+const testFontPath = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+
+// loadTestFont loads a system font with Cyrillic glyphs, skipping the test without one
+func loadTestFont(t *testing.T) *Font {
+	t.Helper()
+	path := os.Getenv("INVOICE_FONT_PATH")
+	if path == "" {
+		path = testFontPath
+	}
+	font, err := LoadFont(path)
+	if err != nil {
+		t.Skip("test font is not available:", err)
+	}
+	return font
+}
+
+func TestLoadFont(t *testing.T) {
+	font := loadTestFont(t)
+
+	if font.name == "" || len(font.advances) == 0 || font.unitsPerEm == 0 {
+		t.Fatalf("font parsed without name, glyphs or units per em: %q %d %d", font.name, len(font.advances), font.unitsPerEm)
+	}
+	for _, r := range "AzЖжЁёЎўҚқҒғҲҳ№«»0" {
+		if font.Glyph(r) == 0 {
+			t.Errorf("no glyph for %q", r)
+		}
+	}
+	if font.Glyph('\U0010FFFD') != 0 {
+		t.Error("a private-use code point mapped to a glyph")
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	font := loadTestFont(t)
+
+	if w := font.TextWidth("", 10); w != 0 {
+		t.Errorf("empty text is %v wide", w)
+	}
+	one := font.TextWidth("Ж", 10)
+	if one <= 0 || one > 20 {
+		t.Fatalf("one glyph at 10pt is %v wide", one)
+	}
+	if three := font.TextWidth("ЖЖЖ", 10); three != 3*one {
+		t.Errorf("three glyphs are %v wide, want %v", three, 3*one)
+	}
+	if doubled := font.TextWidth("Ж", 20); doubled != 2*one {
+		t.Errorf("twice the size is %v wide, want %v", doubled, 2*one)
+	}
+	if font.TextWidth("iii", 10) >= font.TextWidth("WWW", 10) {
+		t.Error("narrow glyphs measure as wide as broad ones")
+	}
+}
+
+func TestParseFontRejectsInvalidData(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": {0, 1, 0, 0, 0, 5},
+		"not a ttf": []byte("%PDF-1.4 definitely not a font file"),
+	} {
+		if _, err := ParseFont("Bad", data); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Package pubsub carries messages between server instances, so an event published on one
+// instance reaches WebSocket clients connected to any of them.
+package pubsub
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Handler receives every message published on any topic
+type Handler func(topic string, payload []byte)
+
+// Broker publishes messages to all subscribers, the publishing instance included
+type Broker interface {
+	Publish(topic string, payload []byte) error
+	Subscribe(handler Handler) error
+	Close() error
+}
+
+// Open returns the broker for a URL: an in-process broker when the URL is empty, or a
+// Redis broker for redis://[:password@]host:port. Channels are named prefix + topic.
+func Open(rawURL, prefix string) (Broker, error) {
+	if rawURL == "" {
+		return NewMemory(), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		return NewRedis(u, prefix), nil
+	}
+	return nil, fmt.Errorf("unsupported pub/sub URL scheme %q", u.Scheme)
+}
+
+// Memory delivers messages within the process. It is enough for a single instance.
+type Memory struct {
+	mutex    sync.RWMutex
+	handlers []Handler
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(topic string, payload []byte) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, handler := range m.handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(handler Handler) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handlers = append(m.handlers, handler)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
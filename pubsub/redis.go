@@ -0,0 +1,364 @@
+package pubsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+	redisMaxBackoff  = 30 * time.Second
+
+	// The subscriber pings the server this often. A connection that sends nothing, not
+	// even a pong, for redisPongWait is taken as dead, even when TCP hasn't noticed.
+	redisPingPeriod = 30 * time.Second
+	redisPongWait   = redisPingPeriod + redisIOTimeout
+)
+
+// Redis is a broker on Redis PUBLISH and PSUBSCRIBE, spoken directly over RESP. One
+// connection publishes; another subscribes to every channel under the prefix and
+// reconnects with backoff when the server goes away. Messages published while the
+// subscriber is disconnected are lost, as with any Redis pub/sub.
+type Redis struct {
+	addr     string
+	username string
+	password string
+	prefix   string
+
+	pingPeriod time.Duration
+	pongWait   time.Duration
+
+	publishMutex sync.Mutex
+	publishConn  *redisConn
+
+	mutex      sync.Mutex
+	handlers   []Handler
+	started    bool
+	subscribed bool // The subscriber connection is up
+	closed     chan struct{}
+	subConn    *redisConn
+}
+
+// NewRedis returns a broker for redis://[user:password@]host:port. It connects lazily.
+func NewRedis(u *url.URL, prefix string) *Redis {
+	r := &Redis{
+		addr:       u.Host,
+		prefix:     prefix,
+		pingPeriod: redisPingPeriod,
+		pongWait:   redisPongWait,
+		closed:     make(chan struct{}),
+	}
+	if !strings.Contains(r.addr, ":") {
+		r.addr += ":6379"
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+		if r.password == "" {
+			// redis://secret@host is a common way to give just the password
+			r.username, r.password = "", r.username
+		}
+	}
+	return r
+}
+
+func (r *Redis) Publish(topic string, payload []byte) error {
+	r.publishMutex.Lock()
+	defer r.publishMutex.Unlock()
+
+	// A connection that broke since the last publish is only noticed on use, so retry once
+	for attempt := 0; ; attempt++ {
+		if r.publishConn == nil {
+			conn, err := r.dial()
+			if err != nil {
+				return err
+			}
+			r.publishConn = conn
+		}
+		_, err := r.publishConn.do("PUBLISH", r.prefix+topic, string(payload))
+		if err == nil {
+			r.deliverUnsubscribed(topic, payload)
+			return nil
+		}
+		r.publishConn.Close()
+		r.publishConn = nil
+		if attempt == 1 {
+			return err
+		}
+	}
+}
+
+// Subscribe registers a handler. The first call starts the subscriber connection.
+func (r *Redis) Subscribe(handler Handler) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers = append(r.handlers, handler)
+	if !r.started {
+		r.started = true
+		go r.subscribeLoop()
+	}
+	return nil
+}
+
+// deliverUnsubscribed hands a published message to the local handlers while the
+// subscriber is reconnecting, since it won't come back from Redis to this instance
+func (r *Redis) deliverUnsubscribed(topic string, payload []byte) {
+	r.mutex.Lock()
+	handlers := r.handlers
+	subscribed := r.subscribed
+	r.mutex.Unlock()
+	if subscribed {
+		return
+	}
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+}
+
+func (r *Redis) Close() error {
+	r.mutex.Lock()
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	if r.subConn != nil {
+		r.subConn.Close()
+	}
+	r.mutex.Unlock()
+
+	r.publishMutex.Lock()
+	defer r.publishMutex.Unlock()
+	if r.publishConn != nil {
+		r.publishConn.Close()
+		r.publishConn = nil
+	}
+	return nil
+}
+
+func (r *Redis) isClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribeLoop keeps a PSUBSCRIBE connection open and hands messages to the handlers
+func (r *Redis) subscribeLoop() {
+	backoff := time.Second
+	for !r.isClosed() {
+		err := r.subscribe(func() { backoff = time.Second })
+		if r.isClosed() {
+			return
+		}
+		log.Printf("Redis pub/sub subscription lost, retrying in %v: %v", backoff, err)
+		select {
+		case <-r.closed:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > redisMaxBackoff {
+			backoff = redisMaxBackoff
+		}
+	}
+}
+
+// subscribe runs one subscriber connection until it fails
+func (r *Redis) subscribe(connected func()) error {
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.mutex.Lock()
+	if r.isClosed() {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.subConn = conn
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		r.subscribed = false
+		r.mutex.Unlock()
+	}()
+
+	if err := conn.send("PSUBSCRIBE", r.prefix+"*"); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go conn.keepAlive(r.pingPeriod, done)
+
+	for {
+		// Every reply, pongs included, moves the deadline
+		conn.SetReadDeadline(time.Now().Add(r.pongWait))
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) == 0 {
+			continue
+		}
+		kind, _ := parts[0].(string)
+		switch {
+		case kind == "psubscribe":
+			r.mutex.Lock()
+			r.subscribed = true
+			r.mutex.Unlock()
+			connected()
+		case kind == "pmessage" && len(parts) == 4:
+			channel, _ := parts[2].(string)
+			payload, _ := parts[3].(string)
+			r.mutex.Lock()
+			handlers := r.handlers
+			r.mutex.Unlock()
+			for _, handler := range handlers {
+				handler(strings.TrimPrefix(channel, r.prefix), []byte(payload))
+			}
+		}
+	}
+}
+
+// keepAlive pings a subscriber connection until done is closed. A subscribed connection
+// answers PING with a "pong" message.
+func (c *redisConn) keepAlive(period time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.send("PING"); err != nil {
+				// The reader times out and reconnects
+				return
+			}
+		}
+	}
+}
+
+func (r *Redis) dial() (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", r.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := conn.do(args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// redisConn speaks RESP, the Redis wire protocol
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// do sends a command and reads its reply; error replies are returned as errors
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	c.SetReadDeadline(time.Now().Add(redisIOTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (c *redisConn) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	c.SetWriteDeadline(time.Now().Add(redisIOTimeout))
+	_, err := c.Write([]byte(b.String()))
+	return err
+}
+
+// read parses one reply: simple strings and bulk strings become string, integers int64,
+// arrays []interface{}, errors redisError, and nil bulk strings or arrays nil
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is just enough of a Redis server for the broker: AUTH, PING, PUBLISH and
+// PSUBSCRIBE with a trailing * pattern
+type fakeRedis struct {
+	listener net.Listener
+
+	mutex       sync.Mutex
+	subscribers map[*redisConn]string // Connection to its pattern prefix
+	subscribes  int                   // PSUBSCRIBE commands received
+	mute        bool                  // Stop answering PINGs, like a server that hung
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{listener: listener, subscribers: make(map[*redisConn]string)}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakeRedis) url() *url.URL {
+	return &url.URL{Scheme: "redis", Host: s.listener.Addr().String()}
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(&redisConn{Conn: netConn, reader: bufio.NewReader(netConn)})
+	}
+}
+
+func (s *fakeRedis) handle(conn *redisConn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.subscribers, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		request, err := conn.read()
+		if err != nil {
+			return
+		}
+		parts, _ := request.([]interface{})
+		args := make([]string, len(parts))
+		for i, part := range parts {
+			args[i], _ = part.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		s.mutex.Lock()
+		_, subscribed := s.subscribers[conn]
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			reply = "+OK\r\n"
+		case "PING":
+			if s.mute {
+				break
+			}
+			if subscribed {
+				reply = "*2\r\n$4\r\npong\r\n$0\r\n\r\n"
+			} else {
+				reply = "+PONG\r\n"
+			}
+		case "PSUBSCRIBE":
+			s.subscribes++
+			s.subscribers[conn] = strings.TrimSuffix(args[1], "*")
+			reply = fmt.Sprintf("*3\r\n$10\r\npsubscribe\r\n%s:1\r\n", bulkString(args[1]))
+		case "PUBLISH":
+			receivers := 0
+			for subscriber, prefix := range s.subscribers {
+				if strings.HasPrefix(args[1], prefix) {
+					subscriber.Write([]byte("*4\r\n$8\r\npmessage\r\n" +
+						bulkString(prefix+"*") + bulkString(args[1]) + bulkString(args[2])))
+					receivers++
+				}
+			}
+			reply = fmt.Sprintf(":%d\r\n", receivers)
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mutex.Unlock()
+
+		if reply != "" {
+			conn.Write([]byte(reply))
+		}
+	}
+}
+
+func (s *fakeRedis) setMute(mute bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.mute = mute
+}
+
+func (s *fakeRedis) subscribeCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.subscribes
+}
+
+func bulkString(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+type received struct {
+	topic   string
+	payload string
+}
+
+func newBroker(t *testing.T, server *fakeRedis) *Redis {
+	broker := NewRedis(server.url(), "test:")
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+// subscribeBroker subscribes the broker and waits until the server confirms it
+func subscribeBroker(t *testing.T, broker *Redis) chan received {
+	t.Helper()
+	messages := make(chan received, 16)
+	broker.Subscribe(func(topic string, payload []byte) {
+		messages <- received{topic, string(payload)}
+	})
+	waitFor(t, "the subscription", func() bool {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+		return broker.subscribed
+	})
+	return messages
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectMessage(t *testing.T, messages chan received, want received) {
+	t.Helper()
+	select {
+	case got := <-messages:
+		if got != want {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%+v was not delivered", want)
+	}
+}
+
+// A message published on one instance reaches the subscribers of every instance
+func TestRedisDeliversAcrossBrokers(t *testing.T) {
+	server := newFakeRedis(t)
+	first := newBroker(t, server)
+	firstMessages := subscribeBroker(t, first)
+	secondMessages := subscribeBroker(t, newBroker(t, server))
+
+	if err := first.Publish("orders", []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := received{"orders", `{"id":1}`}
+	expectMessage(t, secondMessages, want)
+	expectMessage(t, firstMessages, want)
+}
+
+// A subscriber connection that stops answering pings is replaced
+func TestRedisReconnectsSilentSubscriber(t *testing.T) {
+	server := newFakeRedis(t)
+	subscriber := newBroker(t, server)
+	subscriber.pingPeriod, subscriber.pongWait = 50*time.Millisecond, 200*time.Millisecond
+	messages := subscribeBroker(t, subscriber)
+	publisher := newBroker(t, server)
+
+	// Pongs keep a healthy connection open past several ping periods
+	time.Sleep(10 * subscriber.pingPeriod)
+	if count := server.subscribeCount(); count != 1 {
+		t.Fatalf("subscribed %d times over a healthy connection, want 1", count)
+	}
+
+	server.setMute(true)
+	waitFor(t, "the subscriber to reconnect", func() bool { return server.subscribeCount() == 2 })
+	server.setMute(false)
+
+	waitFor(t, "the message", func() bool {
+		// Until the new subscription is confirmed the publisher delivers nothing remotely
+		if err := publisher.Publish("orders", []byte("after")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-messages:
+			return got == received{"orders", "after"}
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	})
+}
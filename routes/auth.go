@@ -0,0 +1,426 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+
+	tokenKindUser  = "user"
+	tokenKindAdmin = "admin"
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("token expired")
+)
+
+var (
+	tokenSecret     = loadTokenSecret()
+	accessTokenTTL  = time.Duration(envInt("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute
+	refreshTokenTTL = time.Duration(envInt("REFRESH_TOKEN_TTL_DAYS", 30)) * 24 * time.Hour
+)
+
+// tokenHeader is the fixed JWT header for HMAC-SHA256 signed tokens
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims is the payload carried by access and refresh tokens
+type tokenClaims struct {
+	Subject   uint   `json:"sub"`
+	Kind      string `json:"kind"`
+	Type      string `json:"typ"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// TokenPair is returned by login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// loadTokenSecret reads the signing secret from JWT_SECRET, falling back to a random
+// per-process secret so tokens at least stay unforgeable in development
+func loadTokenSecret() []byte {
+	if secret := envString("JWT_SECRET", ""); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("Failed to generate token secret:", err)
+	}
+	log.Println("JWT_SECRET is not set, using a random secret; tokens will not survive a restart")
+	return secret
+}
+
+func signToken(claims tokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + tokenSignature(unsigned), nil
+}
+
+func tokenSignature(unsigned string) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parseToken(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, errInvalidToken
+	}
+	expected := tokenSignature(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errExpiredToken
+	}
+	return &claims, nil
+}
+
+// issueTokens signs a fresh access token and stores a new refresh token for the subject
+func issueTokens(kind string, subjectID uint) (*TokenPair, error) {
+	now := time.Now()
+
+	access, err := signToken(tokenClaims{
+		Subject:   subjectID,
+		Kind:      kind,
+		Type:      tokenTypeAccess,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := models.RefreshToken{
+		TokenID:   uuid.New().String(),
+		Kind:      kind,
+		SubjectID: subjectID,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	refresh, err := signToken(tokenClaims{
+		Subject:   subjectID,
+		Kind:      kind,
+		Type:      tokenTypeRefresh,
+		ID:        refreshToken.TokenID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.DB.Create(&refreshToken).Error; err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeRefreshToken marks the refresh token as used. It reports false when the token
+// was already revoked, which makes rotation safe against concurrent reuse.
+func revokeRefreshToken(claims *tokenClaims) (bool, error) {
+	now := time.Now()
+	result := db.DB.Model(&models.RefreshToken{}).
+		Where("token_id = ? AND kind = ? AND subject_id = ? AND revoked_at IS NULL AND expires_at > ?",
+			claims.ID, claims.Kind, claims.Subject, now).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func parseRefreshRequest(c *fiber.Ctx, kind string) (*tokenClaims, error) {
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if err := validate.Struct(&req); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "refresh_token is required",
+		})
+	}
+
+	claims, err := parseToken(req.RefreshToken)
+	if err != nil || claims.Type != tokenTypeRefresh || claims.Kind != kind || claims.ID == "" {
+		return nil, unauthorized(c, "Invalid or expired refresh token")
+	}
+	return claims, nil
+}
+
+// rotateRefreshToken revokes the presented refresh token and issues a new pair
+func rotateRefreshToken(c *fiber.Ctx, kind string) error {
+	claims, err := parseRefreshRequest(c, kind)
+	if claims == nil {
+		return err
+	}
+
+	revoked, err := revokeRefreshToken(claims)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh token",
+		})
+	}
+	if !revoked {
+		return unauthorized(c, "Invalid or expired refresh token")
+	}
+
+	// The account may have been removed since the token was issued
+	var exists int64
+	if kind == tokenKindAdmin {
+		db.DB.Model(&models.Admin{}).Where("id = ?", claims.Subject).Count(&exists)
+	} else {
+		db.DB.Model(&models.User{}).Where("id = ?", claims.Subject).Count(&exists)
+	}
+	if exists == 0 {
+		return unauthorized(c, "Account no longer exists")
+	}
+
+	tokens, err := issueTokens(kind, claims.Subject)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue tokens",
+		})
+	}
+
+	return c.JSON(tokens)
+}
+
+func revokeSession(c *fiber.Ctx, kind string) error {
+	claims, err := parseRefreshRequest(c, kind)
+	if claims == nil {
+		return err
+	}
+
+	if _, err := revokeRefreshToken(claims); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Logged out successfully",
+	})
+}
+
+// refreshHandler - POST /api/refresh
+func refreshHandler(c *fiber.Ctx) error {
+	return rotateRefreshToken(c, tokenKindUser)
+}
+
+// logoutHandler - POST /api/logout
+func logoutHandler(c *fiber.Ctx) error {
+	return revokeSession(c, tokenKindUser)
+}
+
+// adminRefreshHandler - POST /api/admin/refresh
+func adminRefreshHandler(c *fiber.Ctx) error {
+	return rotateRefreshToken(c, tokenKindAdmin)
+}
+
+// adminLogoutHandler - POST /api/admin/logout
+func adminLogoutHandler(c *fiber.Ctx) error {
+	return revokeSession(c, tokenKindAdmin)
+}
+
+func unauthorized(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+func forbidden(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "You do not have permission to perform this action",
+	})
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// accessClaims validates the bearer access token of the request
+func accessClaims(c *fiber.Ctx) (*tokenClaims, error) {
+	token := bearerToken(c)
+	if token == "" {
+		return nil, unauthorized(c, "Authentication required")
+	}
+	claims, err := parseToken(token)
+	if err != nil || claims.Type != tokenTypeAccess {
+		return nil, unauthorized(c, "Invalid or expired token")
+	}
+	return claims, nil
+}
+
+// loadPrincipal stores the user or admin named by the claims in c.Locals
+func loadPrincipal(c *fiber.Ctx, claims *tokenClaims) bool {
+	switch claims.Kind {
+	case tokenKindUser:
+		var user models.User
+		if err := db.DB.First(&user, claims.Subject).Error; err != nil {
+			return false
+		}
+		c.Locals("user", &user)
+		return true
+	case tokenKindAdmin:
+		var admin models.Admin
+		if err := db.DB.First(&admin, claims.Subject).Error; err != nil {
+			return false
+		}
+		c.Locals("admin", &admin)
+		return true
+	}
+	return false
+}
+
+// requireUser rejects requests without a valid customer access token
+func requireUser(c *fiber.Ctx) error {
+	claims, err := accessClaims(c)
+	if claims == nil {
+		return err
+	}
+	if claims.Kind != tokenKindUser || !loadPrincipal(c, claims) {
+		return unauthorized(c, "Invalid or expired token")
+	}
+	return c.Next()
+}
+
+// optionalUser resolves the customer when a token is sent and lets guests through otherwise
+func optionalUser(c *fiber.Ctx) error {
+	if bearerToken(c) == "" {
+		return c.Next()
+	}
+	return requireUser(c)
+}
+
+// requireAdmin rejects requests without a valid admin access token
+func requireAdmin(c *fiber.Ctx) error {
+	claims, err := accessClaims(c)
+	if claims == nil {
+		return err
+	}
+	if claims.Kind != tokenKindAdmin || !loadPrincipal(c, claims) {
+		return unauthorized(c, "Invalid or expired token")
+	}
+	return c.Next()
+}
+
+// requirePermission only lets through admins whose role grants the permission
+func requirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := accessClaims(c)
+		if claims == nil {
+			return err
+		}
+		if claims.Kind != tokenKindAdmin {
+			return forbidden(c)
+		}
+		if !loadPrincipal(c, claims) {
+			return unauthorized(c, "Invalid or expired token")
+		}
+		if !currentAdmin(c).Can(permission) {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+// adminWrites keeps reads public and requires the permission for every other method.
+// It is mounted on route groups whose writes belong to the admin panel.
+func adminWrites(permission string) fiber.Handler {
+	guard := requirePermission(permission)
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		return guard(c)
+	}
+}
+
+// requireSelfOrPermission lets a customer act on their own :id, and admins holding the permission on any
+func requireSelfOrPermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := accessClaims(c)
+		if claims == nil {
+			return err
+		}
+		if !loadPrincipal(c, claims) {
+			return unauthorized(c, "Invalid or expired token")
+		}
+		if admin := currentAdmin(c); admin != nil {
+			if !admin.Can(permission) {
+				return forbidden(c)
+			}
+			return c.Next()
+		}
+		if strconv.FormatUint(uint64(claims.Subject), 10) != c.Params("id") {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+// currentUser returns the authenticated customer or nil for guests
+func currentUser(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals("user").(*models.User)
+	return user
+}
+
+// currentAdmin returns the authenticated admin or nil
+func currentAdmin(c *fiber.Ctx) *models.Admin {
+	admin, _ := c.Locals("admin").(*models.Admin)
+	return admin
+}
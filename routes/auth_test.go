@@ -0,0 +1,417 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestApp mounts every route on a fresh app backed by a fresh in-memory database
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+	openTestDB(t)
+	return mountTestApp()
+}
+
+// newConcurrentTestApp mounts every route on a fresh app backed by a database file that
+// concurrent requests contend for
+func newConcurrentTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+	openConcurrentTestDB(t)
+	return mountTestApp()
+}
+
+func mountTestApp() *fiber.App {
+	app := fiber.New()
+	mountRoutes(app)
+	return app
+}
+
+// testAdminToken stores an admin with the role and returns an access token for it
+func testAdminToken(t testing.TB, role string) string {
+	t.Helper()
+	admin := models.Admin{Name: role, Login: role, Password: "-", Role: role}
+	if err := db.DB.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := issueTokens(tokenKindAdmin, admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens.AccessToken
+}
+
+// testUserToken stores a customer and returns an access token for them
+func testUserToken(t *testing.T) (models.User, string) {
+	t.Helper()
+	user := models.User{Name: "Customer", Phone: "+998901234567", Password: "-"}
+	if err := db.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := issueTokens(tokenKindUser, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, tokens.AccessToken
+}
+
+// request sends a request with an empty JSON body and an optional bearer token and
+// returns the status
+func request(t *testing.T, app *fiber.App, method, path, token string) int {
+	t.Helper()
+	return requestJSON(t, app, method, path, token, "{}")
+}
+
+// requestJSON sends a request with the JSON body and an optional bearer token and
+// returns the status
+func requestJSON(t *testing.T, app *fiber.App, method, path, token, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// catalogAndContentWrites are the admin panel writes of catalog and CMS resources
+var catalogAndContentWrites = []struct {
+	method, path, permission string
+}{
+	{"POST", "/upload", ""},
+	{"POST", "/api/products", models.PermissionCatalog},
+	{"PUT", "/api/products/1", models.PermissionCatalog},
+	{"DELETE", "/api/products/1", models.PermissionCatalog},
+	{"POST", "/api/bottomCategories", models.PermissionCatalog},
+	{"PUT", "/api/bottomCategories/1", models.PermissionCatalog},
+	{"DELETE", "/api/bottomCategories/1", models.PermissionCatalog},
+	{"POST", "/api/categories", models.PermissionCatalog},
+	{"PUT", "/api/categories/1", models.PermissionCatalog},
+	{"DELETE", "/api/categories/1", models.PermissionCatalog},
+	{"POST", "/api/brands", models.PermissionCatalog},
+	{"PUT", "/api/brands/1", models.PermissionCatalog},
+	{"DELETE", "/api/brands/1", models.PermissionCatalog},
+	{"POST", "/api/banners", models.PermissionContent},
+	{"PUT", "/api/banners/1", models.PermissionContent},
+	{"DELETE", "/api/banners/1", models.PermissionContent},
+	{"POST", "/api/news", models.PermissionContent},
+	{"PUT", "/api/news/1", models.PermissionContent},
+	{"DELETE", "/api/news/1", models.PermissionContent},
+	{"PUT", "/api/price-switch", models.PermissionPriceSwitch},
+	{"POST", "/api/statistics", models.PermissionContent},
+	{"PUT", "/api/statistics", models.PermissionContent},
+}
+
+func TestAnonymousWritesRejected(t *testing.T) {
+	app := newTestApp(t)
+	for _, route := range catalogAndContentWrites {
+		if status := request(t, app, route.method, route.path, ""); status != fiber.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: status %d, want 401", route.method, route.path, status)
+		}
+	}
+}
+
+func TestCustomerWritesRejected(t *testing.T) {
+	app := newTestApp(t)
+	_, token := testUserToken(t)
+	for _, route := range catalogAndContentWrites {
+		status := request(t, app, route.method, route.path, token)
+		if status != fiber.StatusForbidden && status != fiber.StatusUnauthorized {
+			t.Errorf("customer %s %s: status %d, want 401 or 403", route.method, route.path, status)
+		}
+	}
+}
+
+func TestAdminWritesNeedPermission(t *testing.T) {
+	app := newTestApp(t)
+	editor := testAdminToken(t, models.RoleContentEditor)
+	catalog := testAdminToken(t, models.RoleCatalogManager)
+
+	for _, route := range catalogAndContentWrites {
+		if route.permission == "" {
+			continue
+		}
+		for role, token := range map[string]string{models.RoleContentEditor: editor, models.RoleCatalogManager: catalog} {
+			allowed := (&models.Admin{Role: role}).Can(route.permission)
+			status := request(t, app, route.method, route.path, token)
+			if !allowed && status != fiber.StatusForbidden {
+				t.Errorf("%s %s %s: status %d, want 403", role, route.method, route.path, status)
+			}
+			if allowed && (status == fiber.StatusForbidden || status == fiber.StatusUnauthorized) {
+				t.Errorf("%s %s %s: status %d, want the guard to let it through", role, route.method, route.path, status)
+			}
+		}
+	}
+
+	// Uploads only need a signed-in admin
+	if status := request(t, app, "POST", "/upload", editor); status == fiber.StatusUnauthorized || status == fiber.StatusForbidden {
+		t.Errorf("admin upload: status %d, want the guard to let it through", status)
+	}
+}
+
+func TestCatalogAndContentReadsArePublic(t *testing.T) {
+	app := newTestApp(t)
+	for _, record := range []interface{}{
+		&models.Category{Name: "Сварка"}, &models.BottomCategory{Name: "Инверторы", CategoryID: 1},
+		&models.Brand{Name: "Ресанта"}, &models.Product{Name: "Инвертор", CategoryID: 1, BrandID: 1},
+		&models.Banner{}, &models.News{}, &models.Statistics{},
+	} {
+		if err := db.DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{
+		"/api/products", "/api/products/1", "/api/products/search?q=инвертор", "/api/products/suggest?q=инв",
+		"/api/categories", "/api/categories/1", "/api/bottomCategories", "/api/bottomCategories/1",
+		"/api/brands", "/api/brands/1", "/api/banners", "/api/banners/1", "/api/news", "/api/news/1",
+		"/api/price-switch", "/api/statistics",
+	} {
+		if status := request(t, app, "GET", path, ""); status != fiber.StatusOK {
+			t.Errorf("anonymous GET %s: status %d, want 200", path, status)
+		}
+	}
+}
+
+// Admin IDs in the path are numbers; anything else never reaches the query
+func TestAdminIDMustBeNumeric(t *testing.T) {
+	app := newTestApp(t)
+	token := testAdminToken(t, models.RoleOwner)
+	for _, path := range []string{"/api/admin/abc", "/api/admin/1%20OR%201=1", "/api/admin/0"} {
+		if status := requestJSON(t, app, "PUT", path, token, `{"name": "Renamed"}`); status != fiber.StatusBadRequest {
+			t.Errorf("PUT %s returned %d, want 400", path, status)
+		}
+		if status := request(t, app, "DELETE", path, token); status != fiber.StatusBadRequest {
+			t.Errorf("DELETE %s returned %d, want 400", path, status)
+		}
+	}
+}
+
+// Parallel sign-ups on an empty database create a single first owner
+func TestFirstAdminIsCreatedOnce(t *testing.T) {
+	const attempts = 10
+	app := newConcurrentTestApp(t)
+
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	var calls []func()
+	for i := 0; i < attempts; i++ {
+		body := fmt.Sprintf(`{"name": "Owner", "login": "owner%d", "password": "secret-password"}`, i)
+		calls = append(calls, func() {
+			status := requestJSON(t, app, "POST", "/api/admin", "", body)
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		})
+	}
+	runConcurrently(calls...)
+
+	if statuses[fiber.StatusCreated] != 1 || statuses[fiber.StatusUnauthorized] != attempts-1 {
+		t.Errorf("statuses %v, want one 201 and %d 401", statuses, attempts-1)
+	}
+	var admins int64
+	db.DB.Model(&models.Admin{}).Count(&admins)
+	if admins != 1 {
+		t.Errorf("%d admins were created, want 1", admins)
+	}
+}
+
+// refreshTokens posts the refresh token to the path and returns the status and the new pair
+func refreshTokens(t *testing.T, app *fiber.App, path, refreshToken string) (int, TokenPair) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(fmt.Sprintf(`{"refresh_token": %q}`, refreshToken)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var tokens TokenPair
+	if resp.StatusCode == fiber.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, tokens
+}
+
+// Tokens whose signature doesn't match their header and payload are rejected
+func TestTamperedTokenRejected(t *testing.T) {
+	app := newTestApp(t)
+	token := testAdminToken(t, models.RoleContentEditor)
+	if status := request(t, app, "GET", "/api/admin/me", token); status != fiber.StatusOK {
+		t.Fatalf("valid token returned %d", status)
+	}
+	parts := strings.Split(token, ".")
+
+	// Promote the token to another admin by rewriting its payload
+	owner := testAdminToken(t, models.RoleOwner)
+	forged := parts[0] + "." + strings.Split(owner, ".")[1] + "." + parts[2]
+
+	// Sign a token with a secret of our own
+	secret := tokenSecret
+	tokenSecret = []byte("guessed secret")
+	foreign, err := signToken(tokenClaims{Subject: 1, Kind: tokenKindAdmin, Type: tokenTypeAccess, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	tokenSecret = secret
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := []byte(parts[2])
+	signature[0] ^= 1
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	for name, tampered := range map[string]string{
+		"payload":        forged,
+		"signature":      parts[0] + "." + parts[1] + "." + string(signature),
+		"secret":         foreign,
+		"alg none":       unsigned,
+		"no signature":   parts[0] + "." + parts[1],
+		"extra segments": token + "." + parts[2],
+	} {
+		if _, err := parseToken(tampered); err != errInvalidToken {
+			t.Errorf("%s: parseToken returned %v, want %v", name, err, errInvalidToken)
+		}
+		if status := request(t, app, "GET", "/api/admin/me", tampered); status != fiber.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, status)
+		}
+	}
+}
+
+func TestExpiredAccessTokenRejected(t *testing.T) {
+	app := newTestApp(t)
+	admin := models.Admin{Name: "Owner", Login: "owner", Password: "-", Role: models.RoleOwner}
+	if err := db.DB.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expired, err := signToken(tokenClaims{
+		Subject: admin.ID, Kind: tokenKindAdmin, Type: tokenTypeAccess,
+		IssuedAt: now.Add(-time.Hour).Unix(), ExpiresAt: now.Add(-time.Second).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseToken(expired); err != errExpiredToken {
+		t.Errorf("parseToken returned %v, want %v", err, errExpiredToken)
+	}
+	if status := request(t, app, "GET", "/api/admin/me", expired); status != fiber.StatusUnauthorized {
+		t.Errorf("expired token: status %d, want 401", status)
+	}
+}
+
+// A refresh token works once: it is revoked when the pair is rotated
+func TestRefreshTokenRotation(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := testUserToken(t)
+	tokens, err := issueTokens(tokenKindUser, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, rotated := refreshTokens(t, app, "/api/refresh", tokens.RefreshToken)
+	if status != fiber.StatusOK {
+		t.Fatalf("refreshing returned %d", status)
+	}
+	if rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatal("refreshing returned the same refresh token")
+	}
+	if status := request(t, app, "GET", fmt.Sprintf("/api/users/%d", user.ID), rotated.AccessToken); status != fiber.StatusOK {
+		t.Errorf("rotated access token: status %d, want 200", status)
+	}
+
+	// Replaying the old token, e.g. after it leaked, fails
+	if status, _ := refreshTokens(t, app, "/api/refresh", tokens.RefreshToken); status != fiber.StatusUnauthorized {
+		t.Errorf("reused refresh token: status %d, want 401", status)
+	}
+	if status, _ := refreshTokens(t, app, "/api/refresh", rotated.RefreshToken); status != fiber.StatusOK {
+		t.Errorf("rotated refresh token: status %d, want 200", status)
+	}
+
+	// Access tokens and customer tokens can't stand in for the refresh token
+	other, err := issueTokens(tokenKindUser, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := refreshTokens(t, app, "/api/refresh", other.AccessToken); status != fiber.StatusUnauthorized {
+		t.Errorf("access token as refresh token: status %d, want 401", status)
+	}
+	if status, _ := refreshTokens(t, app, "/api/admin/refresh", other.RefreshToken); status != fiber.StatusUnauthorized {
+		t.Errorf("customer refresh token on the admin endpoint: status %d, want 401", status)
+	}
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := testUserToken(t)
+	admin := models.Admin{Name: "Owner", Login: "owner", Password: "-", Role: models.RoleOwner}
+	if err := db.DB.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range []struct {
+		kind     string
+		subject  uint
+		basePath string
+	}{
+		{tokenKindUser, user.ID, "/api"},
+		{tokenKindAdmin, admin.ID, "/api/admin"},
+	} {
+		tokens, err := issueTokens(session.kind, session.subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := fmt.Sprintf(`{"refresh_token": %q}`, tokens.RefreshToken)
+		if status := requestJSON(t, app, "POST", session.basePath+"/logout", "", body); status != fiber.StatusOK {
+			t.Fatalf("%s logout returned %d", session.kind, status)
+		}
+		if status, _ := refreshTokens(t, app, session.basePath+"/refresh", tokens.RefreshToken); status != fiber.StatusUnauthorized {
+			t.Errorf("%s refresh after logout: status %d, want 401", session.kind, status)
+		}
+	}
+}
+
+// Customer tokens never pass the admin guards
+func TestCustomerTokenOnAdminRoutes(t *testing.T) {
+	app := newTestApp(t)
+	user, token := testUserToken(t)
+	testAdminToken(t, models.RoleOwner)
+
+	for _, route := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/api/admin/me", fiber.StatusUnauthorized},
+		{"GET", "/api/admin", fiber.StatusForbidden},
+		{"DELETE", "/api/admin/1", fiber.StatusForbidden},
+		{"GET", "/api/users", fiber.StatusForbidden},
+		{"GET", fmt.Sprintf("/api/users/%d", user.ID+1), fiber.StatusForbidden},
+		{"GET", "/api/orders", fiber.StatusForbidden},
+		{"GET", fmt.Sprintf("/api/users/%d", user.ID), fiber.StatusOK},
+	} {
+		if status := request(t, app, route.method, route.path, token); status != route.status {
+			t.Errorf("customer %s %s: status %d, want %d", route.method, route.path, status, route.status)
+		}
+	}
+	var admins int64
+	db.DB.Model(&models.Admin{}).Count(&admins)
+	if admins != 1 {
+		t.Errorf("%d admins left, want the owner untouched", admins)
+	}
+}
@@ -0,0 +1,404 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	// Share of a delivered order's paid amount credited as bonus, in percent
+	bonusAccrualPercent = envFloat("BONUS_ACCRUAL_PERCENT", 1)
+	// Largest share of an order total that may be paid with bonus, in percent
+	bonusMaxRedeemPercent = envFloat("BONUS_MAX_REDEEM_PERCENT", 50)
+	// How long credited points stay spendable; 0 keeps them forever
+	bonusTTL = time.Duration(envInt("BONUS_TTL_DAYS", 365)) * 24 * time.Hour
+)
+
+var errInsufficientBonus = errors.New("insufficient bonus balance")
+
+// BonusAdjustmentRequest is a manual correction of a user's balance by an admin
+type BonusAdjustmentRequest struct {
+	Amount float64 `json:"amount" validate:"required"` // Positive credits, negative debits
+	Note   string  `json:"note" validate:"required"`
+}
+
+// syncBonusBalance stores the ledger sum as the user's balance
+func syncBonusBalance(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("bonus", gorm.Expr("(SELECT COALESCE(ROUND(SUM(amount), 2), 0) FROM bonus_transactions WHERE user_id = ?)", userID)).Error
+}
+
+// spendableBonus returns the unspent, unexpired credits of a user
+func spendableBonus(tx *gorm.DB) *gorm.DB {
+	return tx.Where("remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+// availableBonus returns how many points the user can spend right now
+func availableBonus(tx *gorm.DB, userID uint) (float64, error) {
+	var total float64
+	err := spendableBonus(tx.Model(&models.BonusTransaction{})).
+		Where("user_id = ?", userID).
+		Select("COALESCE(SUM(remaining), 0)").
+		Scan(&total).Error
+	return roundMoney(total), err
+}
+
+// creditBonus adds points to the user's ledger as a new credit
+func creditBonus(tx *gorm.DB, entry models.BonusTransaction) error {
+	entry.Amount = roundMoney(entry.Amount)
+	if entry.Amount <= 0 {
+		return nil
+	}
+	entry.Remaining = entry.Amount
+	if bonusTTL > 0 && entry.ExpiresAt == nil {
+		expiresAt := time.Now().Add(bonusTTL)
+		entry.ExpiresAt = &expiresAt
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+	return syncBonusBalance(tx, entry.UserID)
+}
+
+// debitBonus takes entry.Amount points from the user's credits, soonest to expire first.
+// With partial set it takes whatever is available instead of failing, and returns the
+// amount actually taken.
+func debitBonus(tx *gorm.DB, entry models.BonusTransaction, partial bool) (float64, error) {
+	amount := roundMoney(math.Abs(entry.Amount))
+	if amount <= 0 {
+		return 0, nil
+	}
+
+	var credits []models.BonusTransaction
+	if err := spendableBonus(tx).
+		Where("user_id = ?", entry.UserID).
+		Order("expires_at IS NULL, expires_at, id").
+		Find(&credits).Error; err != nil {
+		return 0, err
+	}
+
+	var available float64
+	for _, credit := range credits {
+		available += credit.Remaining
+	}
+	available = roundMoney(available)
+	if available < amount {
+		if !partial {
+			return 0, errInsufficientBonus
+		}
+		amount = available
+	}
+	if amount <= 0 {
+		return 0, nil
+	}
+
+	left := amount
+	for _, credit := range credits {
+		if left <= 0 {
+			break
+		}
+		take := math.Min(credit.Remaining, left)
+		if err := tx.Model(&models.BonusTransaction{}).
+			Where("id = ?", credit.ID).
+			Update("remaining", roundMoney(credit.Remaining-take)).Error; err != nil {
+			return 0, err
+		}
+		left = roundMoney(left - take)
+	}
+
+	entry.Amount = -amount
+	entry.Remaining = 0
+	entry.ExpiresAt = nil
+	if err := tx.Create(&entry).Error; err != nil {
+		return 0, err
+	}
+	return amount, syncBonusBalance(tx, entry.UserID)
+}
+
+// redeemableBonus returns how much of the requested bonus may be spent on an order:
+// never more than the customer has or than the allowed share of the order total
+func redeemableBonus(tx *gorm.DB, user *models.User, requested, orderTotal float64) (float64, error) {
+	if requested <= 0 {
+		return 0, nil
+	}
+	if user == nil {
+		return 0, &orderError{fiber.StatusBadRequest, "Sign in to redeem bonus points"}
+	}
+
+	available, err := availableBonus(tx, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	limit := orderTotal * bonusMaxRedeemPercent / 100
+	return roundMoney(math.Max(0, math.Min(requested, math.Min(available, limit)))), nil
+}
+
+// orderBonusTotal sums the ledger entries of one type recorded for an order
+func orderBonusTotal(tx *gorm.DB, orderID uint, entryType string) (float64, error) {
+	var total float64
+	err := tx.Model(&models.BonusTransaction{}).
+		Where("order_id = ? AND type = ?", orderID, entryType).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&total).Error
+	return roundMoney(math.Abs(total)), err
+}
+
+// refundOrderBonus gives back the points redeemed on an order, once
+func refundOrderBonus(tx *gorm.DB, order *models.Order, note string) error {
+	if order.UserID == 0 {
+		return nil
+	}
+	redeemed, err := orderBonusTotal(tx, order.ID, models.BonusRedemption)
+	if err != nil {
+		return err
+	}
+	refunded, err := orderBonusTotal(tx, order.ID, models.BonusRefund)
+	if err != nil {
+		return err
+	}
+	return creditBonus(tx, models.BonusTransaction{
+		UserID:  order.UserID,
+		OrderID: &order.ID,
+		Type:    models.BonusRefund,
+		Amount:  redeemed - refunded,
+		Note:    note,
+	})
+}
+
+// trimOrderBonus gives back the part of the redeemed bonus that exceeds the allowed share
+// of the order's total after its items changed
+func trimOrderBonus(tx *gorm.DB, order *models.Order) error {
+	limit := roundMoney((order.Subtotal - order.DiscountTotal) * bonusMaxRedeemPercent / 100)
+	if order.Bonus <= limit {
+		return nil
+	}
+	excess := order.Bonus - limit
+	order.Bonus = limit
+	if order.UserID == 0 {
+		return nil
+	}
+	return creditBonus(tx, models.BonusTransaction{
+		UserID:  order.UserID,
+		OrderID: &order.ID,
+		Type:    models.BonusRefund,
+		Amount:  excess,
+		Note:    "Order items changed",
+	})
+}
+
+// settleOrderBonus updates the customer's ledger after an order changes status: delivered
+// orders earn bonus, cancelled orders give back what was redeemed and returned orders do
+// both that and take back what they earned
+func settleOrderBonus(tx *gorm.DB, order *models.Order) error {
+	if order.UserID == 0 {
+		return nil
+	}
+
+	switch order.Status {
+	case models.OrderStatusDelivered:
+		accrued, err := orderBonusTotal(tx, order.ID, models.BonusAccrual)
+		if err != nil || accrued > 0 {
+			return err
+		}
+		return creditBonus(tx, models.BonusTransaction{
+			UserID:  order.UserID,
+			OrderID: &order.ID,
+			Type:    models.BonusAccrual,
+			Amount:  order.Price * bonusAccrualPercent / 100,
+		})
+	case models.OrderStatusCancelled:
+		return refundOrderBonus(tx, order, "Order cancelled")
+	case models.OrderStatusReturned:
+		accrued, err := orderBonusTotal(tx, order.ID, models.BonusAccrual)
+		if err != nil {
+			return err
+		}
+		reversed, err := orderBonusTotal(tx, order.ID, models.BonusReversal)
+		if err != nil {
+			return err
+		}
+		// Points already spent elsewhere can't be taken back; reverse what is left
+		if _, err := debitBonus(tx, models.BonusTransaction{
+			UserID:  order.UserID,
+			OrderID: &order.ID,
+			Type:    models.BonusReversal,
+			Amount:  accrued - reversed,
+			Note:    "Order returned",
+		}, true); err != nil {
+			return err
+		}
+		return refundOrderBonus(tx, order, "Order returned")
+	}
+	return nil
+}
+
+// expireBonuses writes off credits whose expiry has passed; userID 0 means all users
+func expireBonuses(userID uint) error {
+	var credits []models.BonusTransaction
+	query := db.DB.Where("remaining > 0 AND expires_at IS NOT NULL AND expires_at <= ?", time.Now())
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Find(&credits).Error; err != nil {
+		return err
+	}
+
+	for _, credit := range credits {
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.BonusTransaction{}).
+				Where("id = ? AND remaining = ?", credit.ID, credit.Remaining).
+				Update("remaining", 0)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			if err := tx.Create(&models.BonusTransaction{
+				UserID:  credit.UserID,
+				OrderID: credit.OrderID,
+				Type:    models.BonusExpiration,
+				Amount:  -credit.Remaining,
+			}).Error; err != nil {
+				return err
+			}
+			return syncBonusBalance(tx, credit.UserID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBonusExpiry periodically writes off expired bonus credits
+func runBonusExpiry(interval time.Duration) {
+	for {
+		if err := expireBonuses(0); err != nil {
+			log.Printf("Bonus expiry failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// getUserBonus - GET /users/:id/bonus
+func getUserBonus(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	if err := expireBonuses(uint(id)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to expire bonus points",
+		})
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	skip := c.QueryInt("skip", 0)
+	limit := c.QueryInt("limit", 20)
+	if skip < 0 {
+		skip = 0
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var total int64
+	var transactions []models.BonusTransaction
+	query := db.DB.Model(&models.BonusTransaction{}).Where("user_id = ?", user.ID)
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get bonus history",
+		})
+	}
+	if err := query.Order("id DESC").Offset(skip).Limit(limit).Find(&transactions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get bonus history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"balance":      user.Bonus,
+		"transactions": transactions,
+		"total":        total,
+		"skip":         skip,
+		"limit":        limit,
+	})
+}
+
+// adjustUserBonus - POST /users/:id/bonus/adjustments
+func adjustUserBonus(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req BonusAdjustmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Validation failed: " + err.Error(),
+		})
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	admin := currentAdmin(c)
+	entry := models.BonusTransaction{
+		UserID:  user.ID,
+		AdminID: &admin.ID,
+		Type:    models.BonusAdjustment,
+		Amount:  req.Amount,
+		Note:    req.Note,
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if req.Amount > 0 {
+			return creditBonus(tx, entry)
+		}
+		_, err := debitBonus(tx, entry, false)
+		return err
+	})
+	if errors.Is(err, errInsufficientBonus) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Adjustment exceeds the user's available bonus",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to adjust bonus",
+		})
+	}
+
+	db.DB.First(&user, user.ID)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Bonus adjusted successfully",
+		"balance": user.Bonus,
+	})
+}
@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// creditTestBonus credits the user, optionally with an expiry
+func creditTestBonus(t *testing.T, userID uint, amount float64, expiresAt *time.Time) {
+	t.Helper()
+	err := creditBonus(db.DB, models.BonusTransaction{UserID: userID, Type: models.BonusAdjustment, Amount: amount, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bonusBalance returns the stored balance of the user after checking it matches the ledger
+func bonusBalance(t *testing.T, userID uint) float64 {
+	t.Helper()
+	var user models.User
+	if err := db.DB.First(&user, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var ledger float64
+	db.DB.Model(&models.BonusTransaction{}).Where("user_id = ?", userID).Select("COALESCE(SUM(amount), 0)").Scan(&ledger)
+	if roundMoney(ledger) != user.Bonus {
+		t.Fatalf("balance %v does not match the ledger sum %v", user.Bonus, ledger)
+	}
+	return user.Bonus
+}
+
+// createBonusOrder stores an order of the customer in the given status that redeemed
+// bonus points out of their balance
+func createBonusOrder(t *testing.T, user models.User, status string, price, bonus float64) models.Order {
+	t.Helper()
+	product := createTestProduct(t, "Электроды", 10, price+bonus)
+	order := createTestOrder(t, status, product, 1)
+	order.UserID, order.Price, order.Bonus = user.ID, price, bonus
+	if err := db.DB.Model(&order).Updates(map[string]interface{}{"user_id": user.ID, "price": price, "bonus": bonus}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bonus > 0 {
+		if _, err := debitBonus(db.DB, models.BonusTransaction{UserID: user.ID, OrderID: &order.ID, Type: models.BonusRedemption, Amount: bonus}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return order
+}
+
+func moveOrder(t *testing.T, order *models.Order, statuses ...string) {
+	t.Helper()
+	for _, status := range statuses {
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			return changeOrderStatus(tx, order, status, "", orderActor{Type: "system"})
+		})
+		if err != nil {
+			t.Fatalf("moving order to %s: %v", status, err)
+		}
+	}
+}
+
+func useBonusSettings(t *testing.T, accrualPercent, maxRedeemPercent float64) {
+	t.Helper()
+	accrual, maxRedeem := bonusAccrualPercent, bonusMaxRedeemPercent
+	bonusAccrualPercent, bonusMaxRedeemPercent = accrualPercent, maxRedeemPercent
+	t.Cleanup(func() { bonusAccrualPercent, bonusMaxRedeemPercent = accrual, maxRedeem })
+}
+
+// A delivered order earns bonus on the amount paid, once
+func TestBonusAccruesOnDelivery(t *testing.T) {
+	openTestDB(t)
+	useBonusSettings(t, 5, 50)
+	user, _ := testUserToken(t)
+	creditTestBonus(t, user.ID, 100, nil)
+	order := createBonusOrder(t, user, models.OrderStatusShipped, 900, 100)
+
+	moveOrder(t, &order, models.OrderStatusDelivered)
+	if got := bonusBalance(t, user.ID); got != 45 {
+		t.Errorf("balance is %v after delivery, want 45 (5%% of the 900 paid)", got)
+	}
+
+	// Settling again, e.g. after a retried request, credits nothing more
+	if err := settleOrderBonus(db.DB, &order); err != nil {
+		t.Fatal(err)
+	}
+	if got := bonusBalance(t, user.ID); got != 45 {
+		t.Errorf("balance is %v after settling twice, want 45", got)
+	}
+}
+
+func TestRedeemableBonus(t *testing.T) {
+	openTestDB(t)
+	useBonusSettings(t, 1, 50)
+	user, _ := testUserToken(t)
+	creditTestBonus(t, user.ID, 300, nil)
+	expired := time.Now().Add(-time.Hour)
+	creditTestBonus(t, user.ID, 1000, &expired)
+
+	for _, test := range []struct {
+		requested, orderTotal, want float64
+	}{
+		{100, 1000, 100}, // Within balance and cap
+		{500, 1000, 300}, // Capped by the balance; the expired credit doesn't count
+		{500, 400, 200},  // Capped at half the order
+		{0, 1000, 0},     // Nothing requested
+		{-50, 1000, 0},   // Negative requests redeem nothing
+		{250.555, 1000, 250.56},
+	} {
+		got, err := redeemableBonus(db.DB, &user, test.requested, test.orderTotal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("redeeming %v on %v gives %v, want %v", test.requested, test.orderTotal, got, test.want)
+		}
+	}
+
+	if _, err := redeemableBonus(db.DB, nil, 100, 1000); err == nil {
+		t.Error("a guest redeemed bonus")
+	}
+}
+
+// Cancelling gives back the redeemed points; returning also takes back the accrual
+func TestBonusSettledWhenOrderIsCancelledOrReturned(t *testing.T) {
+	openTestDB(t)
+	useBonusSettings(t, 10, 50)
+	user, _ := testUserToken(t)
+	creditTestBonus(t, user.ID, 200, nil)
+
+	cancelled := createBonusOrder(t, user, models.OrderStatusNew, 500, 150)
+	if got := bonusBalance(t, user.ID); got != 50 {
+		t.Fatalf("balance is %v after redeeming, want 50", got)
+	}
+	moveOrder(t, &cancelled, models.OrderStatusCancelled)
+	if got := bonusBalance(t, user.ID); got != 200 {
+		t.Errorf("balance is %v after cancelling, want 200", got)
+	}
+	if err := refundOrderBonus(db.DB, &cancelled, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if got := bonusBalance(t, user.ID); got != 200 {
+		t.Errorf("balance is %v after refunding twice, want 200", got)
+	}
+
+	returned := createBonusOrder(t, user, models.OrderStatusShipped, 1000, 100)
+	moveOrder(t, &returned, models.OrderStatusDelivered)
+	if got := bonusBalance(t, user.ID); got != 200 {
+		t.Fatalf("balance is %v after delivery, want 200 (100 left and 100 earned)", got)
+	}
+	moveOrder(t, &returned, models.OrderStatusReturned)
+	if got := bonusBalance(t, user.ID); got != 200 {
+		t.Errorf("balance is %v after returning, want 200 (accrual reversed, redemption refunded)", got)
+	}
+	var reversal float64
+	db.DB.Model(&models.BonusTransaction{}).Where("order_id = ? AND type = ?", returned.ID, models.BonusReversal).
+		Select("COALESCE(SUM(amount), 0)").Scan(&reversal)
+	if reversal != -100 {
+		t.Errorf("reversed %v, want -100", reversal)
+	}
+}
+
+// Points earned and already spent can't be reversed; the balance never goes negative
+func TestBonusReversalTakesOnlyWhatIsLeft(t *testing.T) {
+	openTestDB(t)
+	useBonusSettings(t, 10, 100)
+	user, _ := testUserToken(t)
+
+	order := createBonusOrder(t, user, models.OrderStatusShipped, 1000, 0)
+	moveOrder(t, &order, models.OrderStatusDelivered)
+	createBonusOrder(t, user, models.OrderStatusNew, 500, 70) // Spends 70 of the 100 earned
+
+	moveOrder(t, &order, models.OrderStatusReturned)
+	if got := bonusBalance(t, user.ID); got != 0 {
+		t.Errorf("balance is %v after returning, want 0", got)
+	}
+}
+
+// Deleting an order that wasn't delivered gives back the redeemed points
+func TestDeletedOrderRefundsBonus(t *testing.T) {
+	app := newTestApp(t)
+	token := testAdminToken(t, models.RoleOwner)
+	user, _ := testUserToken(t)
+	creditTestBonus(t, user.ID, 100, nil)
+	order := createBonusOrder(t, user, models.OrderStatusConfirmed, 400, 80)
+
+	if status := request(t, app, "DELETE", fmt.Sprintf("/api/orders/%d", order.ID), token); status != fiber.StatusOK {
+		t.Fatalf("deleting the order returned %d", status)
+	}
+	if got := bonusBalance(t, user.ID); got != 100 {
+		t.Errorf("balance is %v after deleting the order, want 100", got)
+	}
+}
+
+// Expired credits are written off, and spending uses the credits expiring first
+func TestBonusExpiry(t *testing.T) {
+	openTestDB(t)
+	user, _ := testUserToken(t)
+	soon := time.Now().Add(time.Hour)
+	later := time.Now().Add(48 * time.Hour)
+	creditTestBonus(t, user.ID, 50, &later)
+	creditTestBonus(t, user.ID, 30, &soon)
+
+	if _, err := debitBonus(db.DB, models.BonusTransaction{UserID: user.ID, Type: models.BonusAdjustment, Amount: 20}, false); err != nil {
+		t.Fatal(err)
+	}
+	var credits []models.BonusTransaction
+	db.DB.Where("user_id = ? AND amount > 0", user.ID).Order("id").Find(&credits)
+	if credits[0].Remaining != 50 || credits[1].Remaining != 10 {
+		t.Errorf("remaining %v and %v, want the credit expiring soonest spent first", credits[0].Remaining, credits[1].Remaining)
+	}
+
+	// Let the soonest credit expire
+	db.DB.Model(&models.BonusTransaction{}).Where("id = ?", credits[1].ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if err := expireBonuses(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := bonusBalance(t, user.ID); got != 50 {
+		t.Errorf("balance is %v after expiry, want 50", got)
+	}
+	if err := expireBonuses(0); err != nil {
+		t.Fatal(err)
+	}
+	var expirations int64
+	db.DB.Model(&models.BonusTransaction{}).Where("user_id = ? AND type = ?", user.ID, models.BonusExpiration).Count(&expirations)
+	if expirations != 1 {
+		t.Errorf("%d expiration entries, want 1", expirations)
+	}
+}
+
+func TestBonusAdjustment(t *testing.T) {
+	app := newTestApp(t)
+	user, userToken := testUserToken(t)
+	adminToken := testAdminToken(t, models.RoleOwner)
+	path := fmt.Sprintf("/api/users/%d/bonus/adjustments", user.ID)
+	creditTestBonus(t, user.ID, 20, nil)
+
+	for _, test := range []struct {
+		token, body string
+		status      int
+		balance     float64
+	}{
+		{adminToken, `{"amount": -50, "note": "Correction"}`, fiber.StatusConflict, 20}, // Would go negative
+		{adminToken, `{"amount": 30, "note": "Gift"}`, fiber.StatusOK, 50},
+		{adminToken, `{"amount": -50, "note": "Correction"}`, fiber.StatusOK, 0},
+		{adminToken, `{"amount": 10}`, fiber.StatusBadRequest, 0}, // A note is required
+		{userToken, `{"amount": 1000, "note": "Mine"}`, fiber.StatusForbidden, 0},
+		{"", `{"amount": 1000, "note": "Mine"}`, fiber.StatusUnauthorized, 0},
+	} {
+		if status := requestJSON(t, app, "POST", path, test.token, test.body); status != test.status {
+			t.Errorf("adjusting with %s returned %d, want %d", test.body, status, test.status)
+		}
+		if got := bonusBalance(t, user.ID); got != test.balance {
+			t.Errorf("balance is %v after %s, want %v", got, test.body, test.balance)
+		}
+	}
+}
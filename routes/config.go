@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+)
+
+// envString returns the value of the environment variable or the fallback when unset
+func envString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envInt returns the integer value of the environment variable or the fallback when unset or invalid
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envFloat returns the float value of the environment variable or the fallback when unset or invalid
+func envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
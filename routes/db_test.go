@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB points db.DB at a fresh in-memory database for the duration of the test
+func openTestDB(t testing.TB) {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	useTestDB(t, fmt.Sprintf("file:%s?mode=memory&cache=shared", name), 1)
+}
+
+// openConcurrentTestDB points db.DB at a fresh database file opened the way
+// db.InitDatabase opens database.db, so concurrent transactions contend for the write
+// lock as they do in production
+func openConcurrentTestDB(t testing.TB) {
+	t.Helper()
+	useTestDB(t, filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate&_busy_timeout=5000", 0)
+}
+
+func useTestDB(t testing.TB, dsn string, maxConns int) {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal("opening test database:", err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxConns > 0 {
+		sqlDB.SetMaxOpenConns(maxConns)
+	}
+
+	previous := db.DB
+	db.DB = conn
+	db.Migrate()
+	t.Cleanup(func() {
+		sqlDB.Close()
+		db.DB = previous
+	})
+}
+
+// createTestProduct stores a product with the given stock and price
+func createTestProduct(t *testing.T, name string, quantity uint, price float64) models.Product {
+	t.Helper()
+	product := models.Product{Name: name, Quantity: quantity, Price: price, Rating: 5}
+	if err := db.DB.Create(&product).Error; err != nil {
+		t.Fatal("creating product:", err)
+	}
+	return product
+}
+
+// productQuantity returns the stock of a product as stored
+func productQuantity(t *testing.T, productID uint) uint {
+	t.Helper()
+	var product models.Product
+	if err := db.DB.First(&product, productID).Error; err != nil {
+		t.Fatal("loading product:", err)
+	}
+	return product.Quantity
+}
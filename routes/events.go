@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+
+	"weldmart/db"
+	"weldmart/models"
+)
+
+// Server events pushed to WebSocket topics
+const (
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventProductLowStock    = "product.low_stock"
+)
+
+// Products at or below this quantity after a sale or reservation raise a low stock event
+var lowStockThreshold = envInt("LOW_STOCK_THRESHOLD", 5)
+
+type OrderStatusChangedEvent struct {
+	OrderID uint   `json:"order_id"`
+	Number  string `json:"number"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Note    string `json:"note,omitempty"`
+}
+
+type LowStockEvent struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Quantity  uint   `json:"quantity"`
+	Threshold int    `json:"threshold"`
+}
+
+// publishEvent sends a server event to the subscribers of a topic
+func publishEvent(topic, eventType string, data interface{}) {
+	hub.publish(topic, eventType, data)
+}
+
+// publishOrderStatusChanged tells the admin feed and the order's own topic about a status change
+func publishOrderStatusChanged(event OrderStatusChangedEvent) {
+	publishEvent(topicAdmin, EventOrderStatusChanged, event)
+	publishEvent(fmt.Sprintf("%s%d", topicOrderPrefix, event.OrderID), EventOrderStatusChanged, event)
+}
+
+// publishLowStock raises a low stock event for each of the products that is at or below
+// the threshold. Call it after the transaction that took the stock has committed.
+func publishLowStock(productIDs []uint) {
+	if len(productIDs) == 0 {
+		return
+	}
+	var products []models.Product
+	if err := db.DB.Select("id", "name", "quantity").
+		Where("id IN ? AND quantity <= ?", productIDs, lowStockThreshold).
+		Find(&products).Error; err != nil {
+		log.Printf("Failed to check low stock: %v", err)
+		return
+	}
+	for _, product := range products {
+		publishEvent(topicAdmin, EventProductLowStock, LowStockEvent{
+			ProductID: product.ID,
+			Name:      product.Name,
+			Quantity:  product.Quantity,
+			Threshold: lowStockThreshold,
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+	"weldmart/xlsx"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Orders are read in batches of this size so an export never holds the whole range
+const exportBatchSize = 500
+
+var orderExportColumns = []string{
+	"order_id", "number", "created_at", "order_type", "status", "organization", "inn",
+	"name", "phone", "service_mode", "subtotal", "discount_total", "bonus", "price",
+	"product_id", "product_name", "quantity", "unit_price", "unit_discount", "line_total",
+}
+
+// orderExportRows returns one row per item of the order, each repeating the order columns.
+// An order without items still gets a row so its totals show up.
+func orderExportRows(order models.Order) [][]interface{} {
+	header := []interface{}{
+		order.ID, order.Number, order.CreatedAt, order.OrderType, order.Status,
+		order.Organization, order.INN, order.Name, order.Phone, order.Service,
+		order.Subtotal, order.DiscountTotal, order.Bonus, order.Price,
+	}
+	if len(order.OrderItems) == 0 {
+		return [][]interface{}{header}
+	}
+
+	rows := make([][]interface{}, 0, len(order.OrderItems))
+	for _, item := range order.OrderItems {
+		row := append(append([]interface{}{}, header...),
+			item.ProductID, item.Product.Name, item.Quantity, item.UnitPrice, item.UnitDiscount, item.LineTotal)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// eachOrderBatch walks the orders matched by query in id order, a batch at a time
+func eachOrderBatch(query *gorm.DB, fn func([]models.Order) error) error {
+	var lastID uint
+	for {
+		var orders []models.Order
+		if err := query.Session(&gorm.Session{}).
+			Preload("OrderItems", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+			Preload("OrderItems.Product").
+			Where("orders.id > ?", lastID).
+			Order("orders.id").
+			Limit(exportBatchSize).
+			Find(&orders).Error; err != nil {
+			return err
+		}
+		if len(orders) == 0 {
+			return nil
+		}
+		if err := fn(orders); err != nil {
+			return err
+		}
+		if len(orders) < exportBatchSize {
+			return nil
+		}
+		lastID = orders[len(orders)-1].ID
+	}
+}
+
+// A phone number or plain number starting with + or -, which spreadsheets don't evaluate
+var csvPlainNumber = regexp.MustCompile(`^[+-]?[0-9 ()-]+$`)
+
+// csvValue formats a cell for CSV. Text that a spreadsheet would take for a formula, such
+// as a customer name starting with "=", gets a leading apostrophe so it stays text.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return v
+		}
+		switch v[0] {
+		case '=', '@', '\t', '\r':
+			return "'" + v
+		case '+', '-':
+			if !csvPlainNumber.MatchString(v) {
+				return "'" + v
+			}
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeOrdersCSV streams the export as CSV. The UTF-8 byte order mark makes Excel read
+// Cyrillic names correctly.
+func writeOrdersCSV(w *bufio.Writer, query *gorm.DB) error {
+	w.WriteString("\uFEFF")
+	out := csv.NewWriter(w)
+	if err := out.Write(orderExportColumns); err != nil {
+		return err
+	}
+	err := eachOrderBatch(query, func(orders []models.Order) error {
+		for _, order := range orders {
+			for _, row := range orderExportRows(order) {
+				record := make([]string, len(row))
+				for i, value := range row {
+					record[i] = csvValue(value)
+				}
+				if err := out.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeOrdersXLSX streams the export as a single-sheet workbook
+func writeOrdersXLSX(w *bufio.Writer, query *gorm.DB) error {
+	out, err := xlsx.NewWriter(w, "Orders")
+	if err != nil {
+		return err
+	}
+	if err := out.WriteHeader(orderExportColumns); err != nil {
+		return err
+	}
+	err = eachOrderBatch(query, func(orders []models.Order) error {
+		for _, order := range orders {
+			for _, row := range orderExportRows(order) {
+				if err := out.WriteRow(row); err != nil {
+					return err
+				}
+			}
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// exportOrders - GET /orders/export?format=csv|xlsx, with the same filters as the order list
+func exportOrders(c *fiber.Ctx) error {
+	format := c.Query("format", "csv")
+	var contentType string
+	var write func(*bufio.Writer, *gorm.DB) error
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		write = writeOrdersCSV
+	case "xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		write = writeOrdersXLSX
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "format must be csv or xlsx",
+		})
+	}
+
+	query, err := applyOrderFilters(c, db.DB.Model(&models.Order{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The body is written after the handler returns, so nothing from c may be used in there.
+	// Once streaming has started the status can't change; failures are only logged and
+	// leave a truncated file.
+	query = query.Session(&gorm.Session{})
+	c.Attachment(fmt.Sprintf("orders-%s.%s", time.Now().Format("20060102-150405"), format))
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := write(w, query); err != nil {
+			log.Printf("Order export failed: %v", err)
+		}
+		w.Flush()
+	})
+	return nil
+}
@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+)
+
+func TestCSVValueEscapesFormulas(t *testing.T) {
+	for _, test := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+998901234567", "+998901234567"},
+		{"+998 (90) 123-45-67", "+998 (90) 123-45-67"},
+		{"-42", "-42"},
+		{"-2+3", "'-2+3"},
+		{"+SUM(A1)", "'+SUM(A1)"},
+		{"-cmd|' /C calc'!A0", "'-cmd|' /C calc'!A0"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"ООО Сварка", "ООО Сварка"},
+		{"a=b", "a=b"},
+		{"", ""},
+		{-12.5, "-12.50"},
+		{-3, "-3"},
+		{uint(7), "7"},
+		{time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), "2024-03-01 12:30:00"},
+	} {
+		if got := csvValue(test.value); got != test.want {
+			t.Errorf("csvValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+// createExportOrders creates count orders of one item each, numbered from 1
+func createExportOrders(t *testing.T, count int) {
+	t.Helper()
+	product := createTestProduct(t, "Электроды", 0, 10)
+	orders := make([]models.Order, count)
+	for i := range orders {
+		orders[i] = models.Order{
+			Number: fmt.Sprintf("WM-TEST-%06d", i+1), Status: "new", OrderType: "individual",
+			Name: "Customer", Phone: "+998901234567", Subtotal: 10, Price: 10,
+			OrderItems: []models.OrderItem{{ProductID: product.ID, Quantity: 1, UnitPrice: 10, LineTotal: 10}},
+		}
+	}
+	orders[count-1].Name = "=cmd|' /C calc'!A0"
+	if err := db.DB.CreateInBatches(orders, 200).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Every order shows up once and in order, however many batches the export takes
+func TestExportOrdersAcrossBatches(t *testing.T) {
+	openTestDB(t)
+	count := 2*exportBatchSize + 1
+	createExportOrders(t, count)
+	query := db.DB.Model(&models.Order{})
+
+	var csvData bytes.Buffer
+	w := bufio.NewWriter(&csvData)
+	if err := writeOrdersCSV(w, query); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(csvData.String(), "\uFEFF"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != count+1 {
+		t.Fatalf("CSV has %d rows, want %d orders and a header", len(records), count)
+	}
+	for i, record := range records[1:] {
+		if record[0] != strconv.Itoa(i+1) {
+			t.Fatalf("CSV row %d is order %s, want %d", i+1, record[0], i+1)
+		}
+	}
+	if name := records[count][7]; name != "'=cmd|' /C calc'!A0" {
+		t.Errorf("CSV name is %q, want it escaped", name)
+	}
+	if phone := records[1][8]; phone != "+998901234567" {
+		t.Errorf("CSV phone is %q, want it unchanged", phone)
+	}
+
+	var xlsxData bytes.Buffer
+	w = bufio.NewWriter(&xlsxData)
+	if err := writeOrdersXLSX(w, query); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	archive, err := zip.NewReader(bytes.NewReader(xlsxData.Bytes()), int64(xlsxData.Len()))
+	if err != nil {
+		t.Fatal("XLSX is not a zip:", err)
+	}
+	sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sheet.Close()
+	var rows struct {
+		Rows []struct {
+			Cells []struct {
+				Ref   string `xml:"r,attr"`
+				Value string `xml:"v"`
+				Text  string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	data, err := io.ReadAll(sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(data, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Rows) != count+1 {
+		t.Fatalf("XLSX has %d rows, want %d orders and a header", len(rows.Rows), count)
+	}
+	for i, row := range rows.Rows[1:] {
+		if row.Cells[0].Value != strconv.Itoa(i+1) {
+			t.Fatalf("XLSX row %d is order %s, want %d", i+1, row.Cells[0].Value, i+1)
+		}
+	}
+	// Cells are stored as text rather than formulas, so the name is kept as typed
+	name := ""
+	for _, cell := range rows.Rows[count].Cells {
+		if cell.Ref == fmt.Sprintf("H%d", count+1) {
+			name = cell.Text
+		}
+	}
+	if name != "=cmd|' /C calc'!A0" {
+		t.Errorf("XLSX name is %q, want it as typed", name)
+	}
+}
@@ -0,0 +1,645 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+	"weldmart/pubsub"
+
+	"github.com/gorilla/websocket"
+)
+
+// Version of the WebSocket message envelope. Clients send it with every message; the
+// server rejects versions it doesn't speak.
+const wsProtocolVersion = 1
+
+// Topics a connection can subscribe to
+const (
+	topicAdmin         = "admin"    // Server events for the admin panel
+	topicOrderPrefix   = "order:"   // Updates of one order: order:<id>
+	topicSupportPrefix = "support:" // A support conversation: support:<conversation id>
+	topicSupportInbox  = "support"  // New customer messages in every conversation, for admins
+)
+
+// Message types sent by clients
+const (
+	wsTypeSubscribe   = "subscribe"
+	wsTypeUnsubscribe = "unsubscribe"
+	wsTypeMessage     = "message" // Chat message to a support topic
+	wsTypeRead        = "read"    // Read receipt for a support topic
+	wsTypePing        = "ping"
+)
+
+// Message types sent by the server, besides the events in events.go and support.go
+const (
+	wsTypeWelcome      = "welcome"
+	wsTypeSubscribed   = "subscribed"
+	wsTypeUnsubscribed = "unsubscribed"
+	wsTypePong         = "pong"
+	wsTypeError        = "error"
+)
+
+// WSMessage is the envelope of every WebSocket message in both directions. ID is set by
+// the client on requests and echoed on the replies to them.
+type WSMessage struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Topic   string          `json:"topic,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	SentAt  time.Time       `json:"sent_at"`
+}
+
+// WSWelcome is sent once the connection is up and tells who it is authenticated as
+type WSWelcome struct {
+	Version int    `json:"version"`
+	Kind    string `json:"kind"` // user, admin or guest
+	ID      uint   `json:"id"`   // Conversation ID for guests
+}
+
+type WSError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// WSSubscribeData is the optional data of a subscribe message. On a support topic the
+// messages after LastID are sent again, so a reconnecting client catches up.
+type WSSubscribeData struct {
+	LastID uint `json:"last_id"`
+}
+
+// Origins allowed to open WebSocket connections, comma separated; "*" allows any. When
+// unset only same-origin pages (and clients that send no Origin) may connect.
+var wsAllowedOrigins = envString("WS_ALLOWED_ORIGINS", "")
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkWSOrigin,
+}
+
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if wsAllowedOrigins == "" {
+		return strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://"), r.Host)
+	}
+	for _, allowed := range strings.Split(wsAllowedOrigins, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Connection timing, following the gorilla/websocket chat example
+const (
+	wsWriteWait      = 10 * time.Second    // Time allowed to write one message
+	wsPongWait       = 60 * time.Second    // Time allowed between pongs from the client
+	wsPingPeriod     = wsPongWait * 9 / 10 // Must be shorter than wsPongWait
+	wsMaxMessageSize = 8 * 1024
+)
+
+// Close codes sent when a connection loses its authorization, mirroring 401 and 403
+const (
+	wsCloseUnauthorized = 4401 // The token expired or the account is gone
+	wsCloseForbidden    = 4403 // The account may no longer read a subscribed topic
+)
+
+// Messages waiting for a connection's writer. A client that falls this far behind is
+// disconnected rather than holding everyone else up.
+var wsSendQueueSize = envInt("WS_SEND_QUEUE_SIZE", 256)
+
+// wsClient is one authenticated connection. Exactly one of user, admin and guest is set.
+// All writes go through the send queue and are done by the connection's writer goroutine,
+// except the close frame sent when authorization is lost.
+type wsClient struct {
+	conn      *websocket.Conn
+	mutex     sync.Mutex // Serializes handling requests with reloading user and admin
+	user      *models.User
+	admin     *models.Admin
+	guest     *models.SupportConversation // A guest may only use their own conversation
+	expiresAt time.Time                   // When the access token expires; zero for guests
+	send      chan []byte
+	done      chan struct{} // Closed when the connection is shutting down
+	closeOnce sync.Once
+	topics    map[string]bool
+}
+
+// wsHub keeps track of the connections and the topics they subscribed to
+type wsHub struct {
+	mutex    sync.RWMutex
+	topics   map[string]map[*wsClient]bool
+	broker   pubsub.Broker
+	outgoing chan brokerMessage
+}
+
+type brokerMessage struct {
+	topic   string
+	payload []byte
+}
+
+var hub = &wsHub{
+	topics:   make(map[string]map[*wsClient]bool),
+	outgoing: make(chan brokerMessage, 1024),
+}
+
+// start connects the hub to the pub/sub broker that carries messages between server
+// instances: Redis when PUBSUB_URL is set, otherwise this process only
+func (h *wsHub) start() {
+	broker, err := pubsub.Open(envString("PUBSUB_URL", ""), envString("PUBSUB_PREFIX", "weldmart:ws:"))
+	if err != nil {
+		log.Fatalf("Failed to open pub/sub broker: %v", err)
+	}
+	h.broker = broker
+	if err := broker.Subscribe(h.deliver); err != nil {
+		log.Fatalf("Failed to subscribe to pub/sub broker: %v", err)
+	}
+	go h.run()
+}
+
+// run hands published messages to the broker. If the broker can't take one, this
+// instance's own clients still get it.
+func (h *wsHub) run() {
+	for message := range h.outgoing {
+		if err := h.broker.Publish(message.topic, message.payload); err != nil {
+			log.Printf("Failed to publish to pub/sub broker: %v", err)
+			h.deliver(message.topic, message.payload)
+		}
+	}
+}
+
+// publish sends a message to a topic's subscribers on every instance. It never blocks the
+// caller: when the queue is full the message is dropped and logged.
+func (h *wsHub) publish(topic, messageType string, data interface{}) {
+	message, err := encodeWSMessage(topic, messageType, "", data)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", messageType, err)
+		return
+	}
+	select {
+	case h.outgoing <- brokerMessage{topic: topic, payload: message}:
+	default:
+		log.Printf("Pub/sub queue full, dropped %s message for %s", messageType, topic)
+	}
+}
+
+// deliver queues a message from the broker for this instance's subscribers of the topic
+func (h *wsHub) deliver(topic string, message []byte) {
+	h.mutex.RLock()
+	subscribers := make([]*wsClient, 0, len(h.topics[topic]))
+	for client := range h.topics[topic] {
+		subscribers = append(subscribers, client)
+	}
+	h.mutex.RUnlock()
+
+	for _, client := range subscribers {
+		if client.expired() {
+			client.closeWith(wsCloseUnauthorized, "Token expired")
+			continue
+		}
+		client.enqueue(message)
+	}
+}
+
+func (h *wsHub) subscribe(client *wsClient, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*wsClient]bool)
+	}
+	h.topics[topic][client] = true
+	client.topics[topic] = true
+}
+
+func (h *wsHub) unsubscribe(client *wsClient, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.topics[topic], client)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+	}
+	delete(client.topics, topic)
+}
+
+// remove drops a closed connection from every topic
+func (h *wsHub) remove(client *wsClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for topic := range client.topics {
+		delete(h.topics[topic], client)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	client.topics = nil
+}
+
+func encodeWSMessage(topic, messageType, id string, data interface{}) ([]byte, error) {
+	var raw json.RawMessage
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		raw = encoded
+	}
+	return json.Marshal(WSMessage{
+		Version: wsProtocolVersion,
+		Type:    messageType,
+		Topic:   topic,
+		ID:      id,
+		Data:    raw,
+		SentAt:  time.Now(),
+	})
+}
+
+// enqueue hands a message to the connection's writer. When the queue is full the client
+// is too slow to keep up and gets disconnected.
+func (c *wsClient) enqueue(message []byte) {
+	select {
+	case <-c.done:
+	case c.send <- message:
+	default:
+		log.Printf("WebSocket client %v is too slow, disconnecting", c.conn.RemoteAddr())
+		c.close()
+	}
+}
+
+// close shuts the connection down; the reader and the writer both exit
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// closeWith tells the client why the connection is closed, then closes it. Control frames
+// may be written alongside the writer goroutine.
+func (c *wsClient) closeWith(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+	c.close()
+}
+
+func (c *wsClient) expired() bool {
+	return !c.expiresAt.IsZero() && !time.Now().Before(c.expiresAt)
+}
+
+// reauthorize checks that the connection may still use its subscriptions: the token
+// hasn't expired, the account still exists, and its current role still grants every
+// topic. Otherwise it closes the connection and reports false.
+func (c *wsClient) reauthorize() bool {
+	code, reason := c.authorize()
+	if code != 0 {
+		c.closeWith(code, reason)
+		return false
+	}
+	return true
+}
+
+// authorize returns the close code and reason for a connection that lost its
+// authorization, or 0
+func (c *wsClient) authorize() (int, string) {
+	if c.expired() {
+		return wsCloseUnauthorized, "Token expired"
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch {
+	case c.admin != nil:
+		var admin models.Admin
+		if err := db.DB.First(&admin, c.admin.ID).Error; err != nil {
+			return wsCloseUnauthorized, "Account no longer exists"
+		}
+		c.admin = &admin
+	case c.user != nil:
+		var user models.User
+		if err := db.DB.First(&user, c.user.ID).Error; err != nil {
+			return wsCloseUnauthorized, "Account no longer exists"
+		}
+		c.user = &user
+	}
+
+	hub.mutex.RLock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	hub.mutex.RUnlock()
+	for _, topic := range topics {
+		if !c.canAccess(topic) {
+			return wsCloseForbidden, "You can no longer read " + topic
+		}
+	}
+	return 0, ""
+}
+
+// reply sends a message to this connection only
+func (c *wsClient) reply(topic, messageType, id string, data interface{}) {
+	message, err := encodeWSMessage(topic, messageType, id, data)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", messageType, err)
+		return
+	}
+	c.enqueue(message)
+}
+
+// writePump writes queued messages and keeps the connection alive with pings. Before
+// each ping the connection is authorized again, so a revoked admin stops getting events.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				select {
+				case <-c.done: // Closed on purpose, e.g. evicted
+				default:
+					log.Printf("WebSocket write error: %v", err)
+				}
+				return
+			}
+		case <-ticker.C:
+			if !c.reauthorize() {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) replyError(request WSMessage, code, message string) {
+	c.reply(request.Topic, wsTypeError, request.ID, WSError{Code: code, Message: message})
+}
+
+// canAccess reports whether the connection may subscribe to the topic. Admin access
+// follows the permissions of the matching REST endpoints.
+func (c *wsClient) canAccess(topic string) bool {
+	switch {
+	case topic == topicAdmin:
+		return c.admin != nil && c.admin.Can(models.PermissionOrders)
+
+	case strings.HasPrefix(topic, topicOrderPrefix):
+		id, err := strconv.ParseUint(strings.TrimPrefix(topic, topicOrderPrefix), 10, 64)
+		if err != nil {
+			return false
+		}
+		if c.admin != nil {
+			return c.admin.Can(models.PermissionOrders)
+		}
+		if c.user == nil {
+			return false
+		}
+		var order models.Order
+		if err := db.DB.Select("id", "user_id").First(&order, id).Error; err != nil {
+			return false
+		}
+		return order.UserID != 0 && order.UserID == c.user.ID
+
+	case topic == topicSupportInbox:
+		return c.admin != nil && c.admin.Can(models.PermissionUsers)
+
+	case strings.HasPrefix(topic, topicSupportPrefix):
+		_, ok := c.supportConversation(topic)
+		return ok
+	}
+	return false
+}
+
+// supportConversation loads the conversation of a support topic if the connection may use it
+func (c *wsClient) supportConversation(topic string) (*models.SupportConversation, bool) {
+	if !strings.HasPrefix(topic, topicSupportPrefix) {
+		return nil, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(topic, topicSupportPrefix), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	var conversation models.SupportConversation
+	if err := db.DB.First(&conversation, id).Error; err != nil {
+		return nil, false
+	}
+	switch {
+	case c.admin != nil:
+		return &conversation, c.admin.Can(models.PermissionUsers)
+	case c.user != nil:
+		return &conversation, conversation.UserID != nil && *conversation.UserID == c.user.ID
+	default:
+		return &conversation, conversation.ID == c.guest.ID
+	}
+}
+
+// reader is the side of a support conversation this connection speaks for
+func (c *wsClient) reader() string {
+	if c.admin != nil {
+		return models.SupportSenderAdmin
+	}
+	return models.SupportSenderCustomer
+}
+
+// handle processes one message from the client
+func (c *wsClient) handle(raw []byte) {
+	var request WSMessage
+	if err := json.Unmarshal(raw, &request); err != nil {
+		c.replyError(request, "bad_request", "Message must be a JSON envelope")
+		return
+	}
+	if request.Version != wsProtocolVersion {
+		c.replyError(request, "unsupported_version", fmt.Sprintf("Protocol version %d is required", wsProtocolVersion))
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch request.Type {
+	case wsTypePing:
+		c.reply("", wsTypePong, request.ID, nil)
+
+	case wsTypeSubscribe:
+		if !c.canAccess(request.Topic) {
+			c.replyError(request, "forbidden", "You cannot subscribe to this topic")
+			return
+		}
+		hub.subscribe(c, request.Topic)
+		c.reply(request.Topic, wsTypeSubscribed, request.ID, nil)
+
+		// Catch up on missed support messages. One published between subscribing and the
+		// query may arrive twice; clients drop duplicates by message ID.
+		var data WSSubscribeData
+		if len(request.Data) > 0 && json.Unmarshal(request.Data, &data) == nil && data.LastID > 0 {
+			if conversation, ok := c.supportConversation(request.Topic); ok {
+				missed, err := supportMessagesAfter(conversation.ID, data.LastID, 500)
+				if err != nil {
+					log.Printf("Failed to load missed support messages: %v", err)
+				}
+				for _, message := range missed {
+					c.reply(request.Topic, EventSupportMessage, "", message)
+				}
+			}
+		}
+
+	case wsTypeUnsubscribe:
+		hub.unsubscribe(c, request.Topic)
+		c.reply(request.Topic, wsTypeUnsubscribed, request.ID, nil)
+
+	case wsTypeMessage:
+		conversation, ok := c.supportConversation(request.Topic)
+		if !ok {
+			c.replyError(request, "forbidden", "You cannot post to this topic")
+			return
+		}
+		var chat SupportMessageRequest
+		if err := json.Unmarshal(request.Data, &chat); err != nil {
+			c.replyError(request, "bad_request", "Message text is required")
+			return
+		}
+		text, err := validateSupportText(chat.Text)
+		if err != nil {
+			c.replyError(request, "bad_request", err.Error())
+			return
+		}
+		if _, err := postSupportMessage(conversation, c.admin, text); err != nil {
+			c.replyError(request, "internal_error", "Failed to send message")
+		}
+
+	case wsTypeRead:
+		conversation, ok := c.supportConversation(request.Topic)
+		if !ok {
+			c.replyError(request, "forbidden", "You cannot read this topic")
+			return
+		}
+		var read SupportReadRequest
+		if err := json.Unmarshal(request.Data, &read); err != nil || read.MessageID == 0 {
+			c.replyError(request, "bad_request", "message_id is required")
+			return
+		}
+		if _, err := markSupportRead(conversation, c.reader(), read.MessageID); err != nil {
+			c.replyError(request, "internal_error", "Failed to mark messages read")
+		}
+
+	default:
+		c.replyError(request, "unknown_type", fmt.Sprintf("Unknown message type %q", request.Type))
+	}
+}
+
+// authenticateWS resolves the user or admin behind the access token of an upgrade request.
+// Browsers can't set headers on a WebSocket, so the token may also come in the token
+// query parameter. Guests connect with the support_token of their conversation instead.
+func authenticateWS(r *http.Request) (*wsClient, bool) {
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); token == "" && len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		token = strings.TrimSpace(header[7:])
+	}
+	if token == "" {
+		guestToken := r.URL.Query().Get("support_token")
+		if guestToken == "" {
+			return nil, false
+		}
+		var conversation models.SupportConversation
+		if err := db.DB.Where("guest_token = ?", guestToken).First(&conversation).Error; err != nil {
+			return nil, false
+		}
+		return &wsClient{guest: &conversation, topics: make(map[string]bool)}, true
+	}
+	claims, err := parseToken(token)
+	if err != nil || claims.Type != tokenTypeAccess {
+		return nil, false
+	}
+
+	client := &wsClient{expiresAt: time.Unix(claims.ExpiresAt, 0), topics: make(map[string]bool)}
+	switch claims.Kind {
+	case tokenKindUser:
+		var user models.User
+		if err := db.DB.First(&user, claims.Subject).Error; err != nil {
+			return nil, false
+		}
+		client.user = &user
+	case tokenKindAdmin:
+		var admin models.Admin
+		if err := db.DB.First(&admin, claims.Subject).Error; err != nil {
+			return nil, false
+		}
+		client.admin = &admin
+	default:
+		return nil, false
+	}
+	return client, true
+}
+
+// serveWS - GET /ws?token= or GET /ws?support_token=
+func serveWS(w http.ResponseWriter, r *http.Request) {
+	client, ok := authenticateWS(r)
+	if !ok {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error upgrading:", err)
+		return
+	}
+	client.conn = conn
+	client.send = make(chan []byte, wsSendQueueSize)
+	client.done = make(chan struct{})
+	defer func() {
+		hub.remove(client)
+		client.close()
+	}()
+	go client.writePump()
+	log.Println("Client connected:", conn.RemoteAddr())
+
+	// A client that stops answering pings is dropped once the read deadline passes
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	welcome := WSWelcome{Version: wsProtocolVersion}
+	switch {
+	case client.admin != nil:
+		welcome.Kind, welcome.ID = tokenKindAdmin, client.admin.ID
+	case client.user != nil:
+		welcome.Kind, welcome.ID = tokenKindUser, client.user.ID
+	default:
+		welcome.Kind, welcome.ID = "guest", client.guest.ID
+	}
+	client.reply("", wsTypeWelcome, "", welcome)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			log.Println("Client disconnected:", conn.RemoteAddr())
+			return
+		}
+		client.handle(message)
+	}
+}
@@ -0,0 +1,299 @@
+package routes
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsTestServer serves /ws for admins of a fresh database and returns the URL to dial
+func wsTestServer(tb testing.TB) string {
+	tb.Helper()
+	openTestDB(tb)
+	token := testAdminToken(tb, models.RoleOwner)
+
+	server := httptest.NewServer(http.HandlerFunc(serveWS))
+	tb.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+		waitForSubscribers(tb, topicAdmin, 0)
+	})
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?token=" + token
+}
+
+// dialAdminTopic connects a client subscribed to the admin topic
+func dialAdminTopic(tb testing.TB, url string) *websocket.Conn {
+	tb.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		tb.Fatal("dialing:", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	subscribeAdminTopic(tb, conn)
+	return conn
+}
+
+// subscribeAdminTopic waits for the welcome and subscribes to the admin topic
+func subscribeAdminTopic(tb testing.TB, conn *websocket.Conn) {
+	tb.Helper()
+	if err := conn.WriteJSON(WSMessage{Version: wsProtocolVersion, Type: wsTypeSubscribe, Topic: topicAdmin}); err != nil {
+		tb.Fatal(err)
+	}
+	for _, want := range []string{wsTypeWelcome, wsTypeSubscribed} {
+		var reply WSMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			tb.Fatal(err)
+		}
+		if reply.Type != want {
+			tb.Fatalf("got a %s message, want %s", reply.Type, want)
+		}
+	}
+}
+
+// waitForSubscribers waits until the topic has the given number of subscribers
+func waitForSubscribers(tb testing.TB, topic string, want int) {
+	tb.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		hub.mutex.RLock()
+		got := len(hub.topics[topic])
+		hub.mutex.RUnlock()
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("topic %s has %d subscribers, want %d", topic, got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// fanOut delivers count messages to the admin topic, each one once every connection has
+// read the previous one, the way a steady stream of events reaches clients that keep up.
+// It returns how many messages went out before the stalled client was evicted, or -1.
+func fanOut(tb testing.TB, conns []*websocket.Conn, stalled *wsClient, payload []byte, count int) int {
+	tb.Helper()
+	received := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn *websocket.Conn) {
+			for i := 0; i < count; i++ {
+				conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+				_, _, err := conn.ReadMessage()
+				received <- err
+				if err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+
+	evictedAt := -1
+	for i := 0; i < count; i++ {
+		hub.deliver(topicAdmin, payload)
+		for range conns {
+			if err := <-received; err != nil {
+				tb.Fatalf("reading message %d: %v", i, err)
+			}
+		}
+		if evictedAt < 0 {
+			select {
+			case <-stalled.done:
+				evictedAt = i + 1
+			default:
+			}
+		}
+	}
+	return evictedAt
+}
+
+// dialStalled subscribes a client that never reads and returns the hub's side of it. It
+// must be the first subscriber. Its receive buffer is kept small so that the server's
+// send queue fills sooner.
+func dialStalled(tb testing.TB, url string) *wsClient {
+	tb.Helper()
+	dialer := *websocket.DefaultDialer
+	dialer.NetDial = func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			err = conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		tb.Fatal("dialing:", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	subscribeAdminTopic(tb, conn)
+
+	waitForSubscribers(tb, topicAdmin, 1)
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for client := range hub.topics[topicAdmin] {
+		return client
+	}
+	return nil
+}
+
+// A client that stops reading is disconnected once its queue fills, while everyone else
+// keeps getting every message
+func TestStalledWSClientIsEvicted(t *testing.T) {
+	const fast, messages = 10, 1000
+	defer func(size int) { wsSendQueueSize = size }(wsSendQueueSize)
+	wsSendQueueSize = 8
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	url := wsTestServer(t)
+	stalled := dialStalled(t, url)
+	var conns []*websocket.Conn
+	for i := 0; i < fast; i++ {
+		conns = append(conns, dialAdminTopic(t, url))
+	}
+	waitForSubscribers(t, topicAdmin, fast+1)
+
+	payload := bytes.Repeat([]byte("x"), 16*1024)
+	start := time.Now()
+	evictedAt := fanOut(t, conns, stalled, payload, messages)
+	t.Logf("%d messages of %d bytes reached %d clients in %v; the stalled client was evicted after %d",
+		messages, len(payload), fast, time.Since(start), evictedAt)
+	if evictedAt < 0 {
+		t.Fatalf("the stalled client is still subscribed after %d messages", messages)
+	}
+
+	// Its reader notices the closed connection and leaves the hub
+	waitForSubscribers(t, topicAdmin, fast)
+}
+
+// BenchmarkWSFanOut delivers messages to many connections while one of them never reads.
+// Each iteration is one message reaching every reading client; evicted-after is how many
+// messages went out before the stalled client was dropped.
+func BenchmarkWSFanOut(b *testing.B) {
+	defer func(size int) { wsSendQueueSize = size }(wsSendQueueSize)
+	wsSendQueueSize = 16
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	payload := bytes.Repeat([]byte("x"), 4*1024)
+	for _, clients := range []int{100, 1000, 3000} {
+		b.Run(strconv.Itoa(clients), func(b *testing.B) {
+			url := wsTestServer(b)
+			stalled := dialStalled(b, url)
+			conns := make([]*websocket.Conn, clients-1)
+			for i := range conns {
+				conns[i] = dialAdminTopic(b, url)
+			}
+			waitForSubscribers(b, topicAdmin, clients)
+
+			b.SetBytes(int64(len(payload) * len(conns)))
+			b.ResetTimer()
+			evictedAt := fanOut(b, conns, stalled, payload, b.N)
+			b.StopTimer()
+
+			b.ReportMetric(float64(b.N*len(conns))/b.Elapsed().Seconds(), "deliveries/s")
+			b.ReportMetric(float64(evictedAt), "evicted-after")
+		})
+	}
+}
+
+// dialAs subscribes to the admin topic with the access token and returns the hub's side
+// of the connection. It must be the only subscriber.
+func dialAs(t *testing.T, url, token string) (*websocket.Conn, *wsClient) {
+	t.Helper()
+	conn := dialAdminTopic(t, strings.Split(url, "?")[0]+"?token="+token)
+	waitForSubscribers(t, topicAdmin, 1)
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for client := range hub.topics[topicAdmin] {
+		return conn, client
+	}
+	return nil, nil
+}
+
+// expectClose reads until the connection is closed and checks the close code
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != code {
+			t.Fatalf("connection ended with %v, want close code %d", err, code)
+		}
+		waitForSubscribers(t, topicAdmin, 0)
+		return
+	}
+}
+
+// An admin whose token expires stops getting events
+func TestWSClosedWhenTokenExpires(t *testing.T) {
+	url := wsTestServer(t)
+	admin := models.Admin{Name: "Manager", Login: "manager", Password: "-", Role: models.RoleOrderManager}
+	if err := db.DB.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Second)
+	token, err := signToken(tokenClaims{Subject: admin.ID, Kind: tokenKindAdmin, Type: tokenTypeAccess, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := dialAs(t, url, token)
+
+	time.Sleep(time.Until(time.Unix(expiresAt.Unix(), 0)))
+	hub.deliver(topicAdmin, []byte(`{"type":"order.created"}`))
+	expectClose(t, conn, wsCloseUnauthorized)
+}
+
+// Role changes and removed accounts take effect on open connections at the next check
+func TestWSReauthorization(t *testing.T) {
+	url := wsTestServer(t)
+	for _, test := range []struct {
+		name   string
+		revoke func(admin *models.Admin) error
+		code   int
+	}{
+		{"role without orders", func(admin *models.Admin) error {
+			return db.DB.Model(admin).Update("role", models.RoleContentEditor).Error
+		}, wsCloseForbidden},
+		{"deleted admin", func(admin *models.Admin) error {
+			return db.DB.Delete(admin).Error
+		}, wsCloseUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			admin := models.Admin{Name: "Manager", Login: "manager-" + test.name, Password: "-", Role: models.RoleOrderManager}
+			if err := db.DB.Create(&admin).Error; err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := issueTokens(tokenKindAdmin, admin.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, client := dialAs(t, url, tokens.AccessToken)
+
+			if !client.reauthorize() {
+				t.Fatal("an authorized connection failed the check")
+			}
+			if err := test.revoke(&admin); err != nil {
+				t.Fatal(err)
+			}
+			if client.reauthorize() {
+				t.Fatal("the connection passed the check after losing access")
+			}
+			expectClose(t, conn, test.code)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	// How long a stored response is replayed for retries with the same key
+	idempotencyTTL = time.Duration(envInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour
+	// After this long a request still marked in progress is assumed lost and may be retried
+	idempotencyLockTimeout = time.Minute
+)
+
+// idempotencyScope keeps keys of different routes and callers apart
+func idempotencyScope(c *fiber.Ctx) string {
+	caller := "guest"
+	if user := currentUser(c); user != nil {
+		caller = fmt.Sprintf("user:%d", user.ID)
+	}
+	return c.Method() + " " + c.Route().Path + " " + caller
+}
+
+// idempotent replays the stored response when a request is retried with the same
+// Idempotency-Key header. A key reused with a different body is rejected with 422, and
+// a retry that arrives while the first request is still running gets 409. Server errors
+// are not stored so the client can retry them.
+func idempotent(c *fiber.Ctx) error {
+	key := c.Get("Idempotency-Key")
+	if key == "" {
+		return c.Next()
+	}
+	if len(key) > 255 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Idempotency-Key must be at most 255 characters",
+		})
+	}
+
+	sum := sha256.Sum256(c.Body())
+	hash := hex.EncodeToString(sum[:])
+	scope := idempotencyScope(c)
+
+	var stored models.IdempotencyKey
+	var replay bool
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{}).Error; err != nil {
+			return err
+		}
+
+		err := tx.Where("key = ? AND scope = ?", key, scope).First(&stored).Error
+		if err == gorm.ErrRecordNotFound {
+			stored = models.IdempotencyKey{
+				Key:         key,
+				Scope:       scope,
+				RequestHash: hash,
+				ExpiresAt:   now.Add(idempotencyTTL),
+			}
+			return tx.Create(&stored).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		if stored.RequestHash != hash {
+			return &orderError{fiber.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body"}
+		}
+		if stored.CompletedAt != nil {
+			replay = true
+			return nil
+		}
+		if now.Sub(stored.CreatedAt) < idempotencyLockTimeout {
+			return &orderError{fiber.StatusConflict, "A request with this Idempotency-Key is still being processed"}
+		}
+		// The first attempt never finished; let this one take over
+		return tx.Model(&stored).Update("created_at", now).Error
+	})
+	if oe, ok := err.(*orderError); ok {
+		return c.Status(oe.Status).JSON(fiber.Map{
+			"error": oe.Message,
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check Idempotency-Key",
+		})
+	}
+
+	if replay {
+		c.Set("Idempotent-Replayed", "true")
+		c.Set(fiber.HeaderContentType, stored.ContentType)
+		return c.Status(stored.StatusCode).Send(stored.ResponseBody)
+	}
+
+	if err := c.Next(); err != nil {
+		db.DB.Delete(&stored)
+		return err
+	}
+
+	status := c.Response().StatusCode()
+	if status >= fiber.StatusInternalServerError {
+		db.DB.Delete(&stored)
+		return nil
+	}
+
+	now := time.Now()
+	db.DB.Model(&stored).Updates(map[string]interface{}{
+		"status_code":   status,
+		"content_type":  string(c.Response().Header.ContentType()),
+		"response_body": c.Response().Body(),
+		"completed_at":  now,
+	})
+	return nil
+}
@@ -0,0 +1,222 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Taxpayer kinds told apart by the first digit of the INN
+const (
+	innKindLegalEntity  = "legal_entity"
+	innKindEntrepreneur = "individual_entrepreneur"
+)
+
+var errOrganizationNotFound = errors.New("organization not found")
+
+// innError describes why an INN is invalid
+type innError struct {
+	Message string
+}
+
+func (e *innError) Error() string {
+	return e.Message
+}
+
+// normalizeINN drops spaces and dashes people type between digit groups
+func normalizeINN(inn string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(inn))
+}
+
+// validateINN checks an Uzbek taxpayer number: 9 digits, starting with 2 or 3 for legal
+// entities and 4 to 6 for individual entrepreneurs. It returns the taxpayer kind. The
+// check digit is not verified: there is no published algorithm to verify it against yet.
+func validateINN(inn string) (string, error) {
+	if len(inn) != 9 {
+		return "", &innError{"INN must be exactly 9 digits"}
+	}
+	for _, r := range inn {
+		if r < '0' || r > '9' {
+			return "", &innError{"INN must contain digits only"}
+		}
+	}
+
+	var kind string
+	switch inn[0] {
+	case '2', '3':
+		kind = innKindLegalEntity
+	case '4', '5', '6':
+		kind = innKindEntrepreneur
+	default:
+		return "", &innError{"INN must start with 2 or 3 for a legal entity or 4 to 6 for an individual entrepreneur"}
+	}
+	return kind, nil
+}
+
+// validateOrganization checks the organization name of a legal order
+func validateOrganization(name string) error {
+	length := len([]rune(strings.TrimSpace(name)))
+	if length == 0 {
+		return errors.New("Organization is required")
+	}
+	if length < 2 || length > 255 {
+		return errors.New("Organization must be between 2 and 255 characters")
+	}
+	return nil
+}
+
+// OrganizationInfo is what a registry knows about a taxpayer
+type OrganizationInfo struct {
+	INN     string `json:"inn"`
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+}
+
+// OrganizationRegistry looks organizations up by INN. It returns errOrganizationNotFound
+// when the registry has no such taxpayer.
+type OrganizationRegistry interface {
+	LookupOrganization(ctx context.Context, inn string) (*OrganizationInfo, error)
+}
+
+// organizationRegistry is nil when no registry is configured
+var organizationRegistry = newOrganizationRegistry()
+
+// newOrganizationRegistry picks the registry from config: a local JSON file (handy as a
+// stub in development) or an HTTP service
+func newOrganizationRegistry() OrganizationRegistry {
+	if path := envString("INN_REGISTRY_FILE", ""); path != "" {
+		return &fileRegistry{path: path}
+	}
+	if endpoint := envString("INN_REGISTRY_URL", ""); endpoint != "" {
+		return &httpRegistry{endpoint: endpoint, client: &http.Client{Timeout: 5 * time.Second}}
+	}
+	return nil
+}
+
+// fileRegistry reads organizations from a JSON array of OrganizationInfo
+type fileRegistry struct {
+	path string
+}
+
+func (r *fileRegistry) LookupOrganization(ctx context.Context, inn string) (*OrganizationInfo, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	var organizations []OrganizationInfo
+	if err := json.Unmarshal(data, &organizations); err != nil {
+		return nil, err
+	}
+	for _, organization := range organizations {
+		if normalizeINN(organization.INN) == inn {
+			organization.INN = inn
+			return &organization, nil
+		}
+	}
+	return nil, errOrganizationNotFound
+}
+
+// httpRegistry calls GET <endpoint> with {inn} replaced by the INN and expects an
+// OrganizationInfo JSON object, or 404 for an unknown INN
+type httpRegistry struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (r *httpRegistry) LookupOrganization(ctx context.Context, inn string) (*OrganizationInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(r.endpoint, "{inn}", url.PathEscape(inn)), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errOrganizationNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned %s", response.Status)
+	}
+	var organization OrganizationInfo
+	if err := json.NewDecoder(response.Body).Decode(&organization); err != nil {
+		return nil, err
+	}
+	if organization.Name == "" {
+		return nil, errOrganizationNotFound
+	}
+	return &organization, nil
+}
+
+// lookupOrganization asks the configured registry for the organization's name. Registry
+// failures are logged and treated as "unknown" so they never block an order.
+func lookupOrganization(ctx context.Context, inn string) *OrganizationInfo {
+	if organizationRegistry == nil {
+		return nil
+	}
+	organization, err := organizationRegistry.LookupOrganization(ctx, inn)
+	if err != nil {
+		if !errors.Is(err, errOrganizationNotFound) {
+			log.Printf("Organization registry lookup failed for %s: %v", inn, err)
+		}
+		return nil
+	}
+	return organization
+}
+
+// validateLegalOrderFields validates the INN and organization of a legal order, filling
+// the organization from the registry when it was left empty. Problems are returned per
+// field.
+func validateLegalOrderFields(ctx context.Context, inn, organization *string) map[string]string {
+	fields := make(map[string]string)
+
+	*inn = normalizeINN(*inn)
+	if *inn == "" {
+		fields["inn"] = "INN is required"
+	} else if _, err := validateINN(*inn); err != nil {
+		fields["inn"] = err.Error()
+	}
+
+	*organization = strings.TrimSpace(*organization)
+	if *organization == "" && fields["inn"] == "" {
+		if info := lookupOrganization(ctx, *inn); info != nil {
+			*organization = info.Name
+		}
+	}
+	if err := validateOrganization(*organization); err != nil {
+		fields["organization"] = err.Error()
+	}
+	return fields
+}
+
+// lookupINN - GET /inn/:inn
+func lookupINN(c *fiber.Ctx) error {
+	inn := normalizeINN(c.Params("inn"))
+	kind, err := validateINN(inn)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fiber.Map{"inn": err.Error()},
+		})
+	}
+
+	response := fiber.Map{
+		"inn":  inn,
+		"kind": kind,
+	}
+	if organization := lookupOrganization(c.UserContext(), inn); organization != nil {
+		response["organization"] = organization
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateINN(t *testing.T) {
+	for _, test := range []struct {
+		inn, kind, err string
+	}{
+		{"201234567", innKindLegalEntity, ""},
+		{"309876543", innKindLegalEntity, ""},
+		{"400000001", innKindEntrepreneur, ""},
+		{"512345678", innKindEntrepreneur, ""},
+		{"612345678", innKindEntrepreneur, ""},
+		{"", "", "INN must be exactly 9 digits"},
+		{"20123456", "", "INN must be exactly 9 digits"},
+		{"2012345678", "", "INN must be exactly 9 digits"},
+		{"20123456a", "", "INN must contain digits only"},
+		{"２01234567", "", "INN must be exactly 9 digits"}, // Fullwidth digit, 3 bytes
+		{"101234567", "", "INN must start with 2 or 3"},
+		{"712345678", "", "INN must start with 2 or 3"},
+		{"012345678", "", "INN must start with 2 or 3"},
+	} {
+		kind, err := validateINN(test.inn)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("validateINN(%q) failed: %v", test.inn, err)
+		case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+			t.Errorf("validateINN(%q) returned %v, want %q", test.inn, err, test.err)
+		case kind != test.kind:
+			t.Errorf("validateINN(%q) kind is %q, want %q", test.inn, kind, test.kind)
+		}
+	}
+}
+
+func TestNormalizeINN(t *testing.T) {
+	for input, want := range map[string]string{
+		" 201 234 567 ": "201234567",
+		"201-234-567":   "201234567",
+		"201234567":     "201234567",
+	} {
+		if got := normalizeINN(input); got != want {
+			t.Errorf("normalizeINN(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestValidateOrganization(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		ok   bool
+	}{
+		{"ООО «Сварка»", true},
+		{"ИП", true},
+		{"", false},
+		{"   ", false},
+		{"A", false},
+		{" Я ", false},
+		{strings.Repeat("Я", 255), true},
+		{strings.Repeat("Я", 256), false},
+	} {
+		if err := validateOrganization(test.name); (err == nil) != test.ok {
+			t.Errorf("validateOrganization(%q) returned %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+}
+
+// useRegistry swaps the configured registry for the duration of the test
+func useRegistry(t *testing.T, registry OrganizationRegistry) {
+	t.Helper()
+	previous := organizationRegistry
+	organizationRegistry = registry
+	t.Cleanup(func() { organizationRegistry = previous })
+}
+
+func TestFileRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "organizations.json")
+	data := `[{"inn": "201 234 567", "name": "ООО Сварка", "address": "Ташкент"}, {"inn": "309876543", "name": "АО Металл"}]`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry := &fileRegistry{path: path}
+
+	organization, err := registry.LookupOrganization(context.Background(), "201234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *organization != (OrganizationInfo{INN: "201234567", Name: "ООО Сварка", Address: "Ташкент"}) {
+		t.Errorf("looked up %+v", organization)
+	}
+	if _, err := registry.LookupOrganization(context.Background(), "400000001"); !errors.Is(err, errOrganizationNotFound) {
+		t.Errorf("unknown INN returned %v, want not found", err)
+	}
+	if _, err := (&fileRegistry{path: filepath.Join(t.TempDir(), "missing.json")}).LookupOrganization(context.Background(), "201234567"); err == nil {
+		t.Error("a missing registry file was not reported")
+	}
+}
+
+func TestHTTPRegistry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/organizations/201234567":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"inn": "201234567", "name": "ООО Сварка"}`))
+		case "/organizations/309876543":
+			w.Write([]byte(`{"inn": "309876543"}`)) // Known, but without a name
+		case "/organizations/500000000":
+			http.Error(w, "registry is down", http.StatusBadGateway)
+		case "/organizations/600000000":
+			w.Write([]byte(`not json`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	registry := &httpRegistry{endpoint: server.URL + "/organizations/{inn}", client: server.Client()}
+	ctx := context.Background()
+
+	organization, err := registry.LookupOrganization(ctx, "201234567")
+	if err != nil || organization.Name != "ООО Сварка" {
+		t.Errorf("looked up %+v, %v", organization, err)
+	}
+	for _, inn := range []string{"400000001", "309876543"} {
+		if _, err := registry.LookupOrganization(ctx, inn); !errors.Is(err, errOrganizationNotFound) {
+			t.Errorf("%s returned %v, want not found", inn, err)
+		}
+	}
+	for _, inn := range []string{"500000000", "600000000"} {
+		if _, err := registry.LookupOrganization(ctx, inn); err == nil || errors.Is(err, errOrganizationNotFound) {
+			t.Errorf("%s returned %v, want a registry failure", inn, err)
+		}
+	}
+
+	// Lookups for orders never fail: registry errors just leave the name to the customer
+	useRegistry(t, registry)
+	if info := lookupOrganization(ctx, "500000000"); info != nil {
+		t.Errorf("a failing registry returned %+v", info)
+	}
+
+	inn, organizationName := " 201-234-567 ", ""
+	if fields := validateLegalOrderFields(ctx, &inn, &organizationName); len(fields) != 0 {
+		t.Errorf("validation failed: %v", fields)
+	}
+	if inn != "201234567" || organizationName != "ООО Сварка" {
+		t.Errorf("INN %q and organization %q, want them normalized and filled from the registry", inn, organizationName)
+	}
+
+	inn, organizationName = "400000001", ""
+	fields := validateLegalOrderFields(ctx, &inn, &organizationName)
+	if fields["organization"] != "Organization is required" || fields["inn"] != "" {
+		t.Errorf("unknown INN without an organization gave %v", fields)
+	}
+
+	inn, organizationName = "123", "ООО Сварка"
+	if fields := validateLegalOrderFields(ctx, &inn, &organizationName); fields["inn"] == "" {
+		t.Errorf("a short INN was accepted: %v", fields)
+	}
+}
+
+func TestLookupINN(t *testing.T) {
+	app := newTestApp(t)
+	useRegistry(t, nil)
+	if status := request(t, app, "GET", "/api/inn/201234567", ""); status != http.StatusOK {
+		t.Errorf("valid INN returned %d, want 200", status)
+	}
+	if status := request(t, app, "GET", "/api/inn/12345", ""); status != http.StatusBadRequest {
+		t.Errorf("invalid INN returned %d, want 400", status)
+	}
+}
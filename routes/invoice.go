@@ -0,0 +1,480 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"weldmart/db"
+	"weldmart/models"
+	"weldmart/pdf"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// invoiceSeller holds the seller requisites printed on invoices
+type invoiceSeller struct {
+	Name       string
+	INN        string
+	Address    string
+	Phone      string
+	Bank       string
+	Account    string // Settlement account (р/с)
+	MFO        string // Bank code
+	Director   string
+	Accountant string
+}
+
+var (
+	invoiceSellerConfig = invoiceSeller{
+		Name:       envString("INVOICE_SELLER_NAME", "ООО «WeldMart»"),
+		INN:        envString("INVOICE_SELLER_INN", ""),
+		Address:    envString("INVOICE_SELLER_ADDRESS", ""),
+		Phone:      envString("INVOICE_SELLER_PHONE", ""),
+		Bank:       envString("INVOICE_SELLER_BANK", ""),
+		Account:    envString("INVOICE_SELLER_ACCOUNT", ""),
+		MFO:        envString("INVOICE_SELLER_MFO", ""),
+		Director:   envString("INVOICE_SELLER_DIRECTOR", ""),
+		Accountant: envString("INVOICE_SELLER_ACCOUNTANT", ""),
+	}
+	// VAT included in the prices; 0 prints "Без НДС"
+	invoiceVATPercent = envFloat("INVOICE_VAT_PERCENT", 12)
+	// TrueType fonts with Cyrillic glyphs
+	invoiceFontPath     = envString("INVOICE_FONT_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf")
+	invoiceBoldFontPath = envString("INVOICE_FONT_BOLD_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans-Bold.ttf")
+)
+
+// invoiceLine is one row of the invoice table
+type invoiceLine struct {
+	No       int
+	Name     string
+	Quantity int
+	Unit     string
+	Price    float64 // Per unit after discount
+	Total    float64
+}
+
+// invoice is the content of an invoice, independent of how it is rendered
+type invoice struct {
+	Title         string
+	Seller        []string
+	Buyer         []string
+	Lines         []invoiceLine
+	Subtotal      float64
+	Bonus         float64
+	Total         float64
+	VATLabel      string
+	VAT           float64
+	Summary       string
+	AmountInWords string
+	Signatures    []string
+}
+
+// buildInvoice lays out the invoice for a legal order from its stored prices
+func buildInvoice(order models.Order, seller invoiceSeller) invoice {
+	inv := invoice{
+		Title: fmt.Sprintf("Счёт на оплату № %s от %s", order.Number, order.CreatedAt.Format("02.01.2006")),
+		Bonus: order.Bonus,
+		Total: order.Price,
+	}
+
+	inv.Seller = append(inv.Seller, "Поставщик: "+joinNonEmpty(", ", seller.Name, prefixed("ИНН ", seller.INN)))
+	if seller.Address != "" || seller.Phone != "" {
+		inv.Seller = append(inv.Seller, "Адрес: "+joinNonEmpty(", ", seller.Address, prefixed("тел. ", seller.Phone)))
+	}
+	if seller.Bank != "" || seller.Account != "" {
+		inv.Seller = append(inv.Seller, "Банк: "+joinNonEmpty(", ", seller.Bank, prefixed("р/с ", seller.Account), prefixed("МФО ", seller.MFO)))
+	}
+	inv.Buyer = append(inv.Buyer, "Покупатель: "+joinNonEmpty(", ", order.Organization, prefixed("ИНН ", order.INN)))
+
+	for i, item := range order.OrderItems {
+		line := invoiceLine{
+			No:       i + 1,
+			Name:     item.Product.Name,
+			Quantity: item.Quantity,
+			Unit:     "шт.",
+			Price:    roundMoney(item.UnitPrice - item.UnitDiscount),
+			Total:    item.LineTotal,
+		}
+		if line.Name == "" {
+			line.Name = fmt.Sprintf("Товар %d", item.ProductID)
+		}
+		inv.Lines = append(inv.Lines, line)
+		inv.Subtotal += item.LineTotal
+	}
+	inv.Subtotal = roundMoney(inv.Subtotal)
+
+	if invoiceVATPercent > 0 {
+		inv.VATLabel = "В том числе НДС " + strconv.FormatFloat(invoiceVATPercent, 'f', -1, 64) + "%:"
+		inv.VAT = roundMoney(inv.Total * invoiceVATPercent / (100 + invoiceVATPercent))
+	} else {
+		inv.VATLabel = "Без НДС"
+	}
+
+	inv.Summary = fmt.Sprintf("Всего наименований %d, на сумму %s сум", len(inv.Lines), formatMoney(inv.Total))
+	inv.AmountInWords = amountInWords(inv.Total)
+	inv.Signatures = []string{
+		"Руководитель ____________________ " + seller.Director,
+		"Бухгалтер ____________________ " + seller.Accountant,
+	}
+	return inv
+}
+
+// Text returns the invoice as plain text, in the order it appears on the page
+func (inv invoice) Text() string {
+	var b strings.Builder
+	b.WriteString(inv.Title + "\n\n")
+	for _, line := range append(append([]string{}, inv.Seller...), inv.Buyer...) {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n№ | Товар | Кол-во | Ед. | Цена | Сумма\n")
+	for _, line := range inv.Lines {
+		fmt.Fprintf(&b, "%d | %s | %d | %s | %s | %s\n", line.No, line.Name, line.Quantity, line.Unit, formatMoney(line.Price), formatMoney(line.Total))
+	}
+	b.WriteString("\n")
+	for _, total := range inv.totals() {
+		b.WriteString(total[0] + " " + total[1] + "\n")
+	}
+	b.WriteString("\n" + inv.Summary + "\n" + inv.AmountInWords + "\n\n")
+	for _, signature := range inv.Signatures {
+		b.WriteString(strings.TrimSpace(signature) + "\n")
+	}
+	return b.String()
+}
+
+// totals returns the label and value rows printed under the table
+func (inv invoice) totals() [][2]string {
+	rows := [][2]string{{"Итого:", formatMoney(inv.Subtotal)}}
+	if inv.Bonus > 0 {
+		rows = append(rows, [2]string{"Оплачено бонусами:", "-" + formatMoney(inv.Bonus)})
+	}
+	if inv.VAT > 0 {
+		rows = append(rows, [2]string{inv.VATLabel, formatMoney(inv.VAT)})
+	} else {
+		rows = append(rows, [2]string{inv.VATLabel, "-"})
+	}
+	return append(rows, [2]string{"Всего к оплате:", formatMoney(inv.Total)})
+}
+
+// Invoice table columns: left edge and width
+var invoiceColumns = [6][2]float64{{40, 25}, {65, 250}, {315, 50}, {365, 35}, {400, 80}, {480, 75}}
+
+// renderInvoice draws the invoice on A4 pages
+func renderInvoice(inv invoice, regular, bold *pdf.Font) []byte {
+	const left, right, bottom = 40.0, 555.0, pdf.PageHeight - 60
+	doc := pdf.New()
+	page := doc.AddPage()
+
+	y := 60.0
+	page.Text(bold, 14, left, y, inv.Title)
+	y += 12
+	page.Line(left, y, right, y, 1)
+	y += 20
+	for _, line := range append(append([]string{}, inv.Seller...), inv.Buyer...) {
+		for _, wrapped := range wrapText(regular, 9, line, right-left) {
+			page.Text(regular, 9, left, y, wrapped)
+			y += 13
+		}
+		y += 3
+	}
+	y += 10
+
+	header := func() {
+		page.Rect(left, y, right-left, 18, 0.7)
+		for i, title := range []string{"№", "Товар", "Кол-во", "Ед.", "Цена", "Сумма"} {
+			page.Text(bold, 9, invoiceColumns[i][0]+4, y+12, title)
+		}
+		y += 18
+	}
+	header()
+
+	for _, line := range inv.Lines {
+		names := wrapText(regular, 9, line.Name, invoiceColumns[1][1]-8)
+		height := float64(len(names))*12 + 6
+		if y+height > bottom {
+			page = doc.AddPage()
+			y = 60
+			header()
+		}
+		page.Rect(left, y, right-left, height, 0.5)
+		page.Text(regular, 9, invoiceColumns[0][0]+4, y+12, strconv.Itoa(line.No))
+		for i, name := range names {
+			page.Text(regular, 9, invoiceColumns[1][0]+4, y+12+float64(i)*12, name)
+		}
+		page.TextRight(regular, 9, invoiceColumns[2][0]+invoiceColumns[2][1]-4, y+12, strconv.Itoa(line.Quantity))
+		page.Text(regular, 9, invoiceColumns[3][0]+4, y+12, line.Unit)
+		page.TextRight(regular, 9, invoiceColumns[4][0]+invoiceColumns[4][1]-4, y+12, formatMoney(line.Price))
+		page.TextRight(regular, 9, right-4, y+12, formatMoney(line.Total))
+		y += height
+	}
+
+	if y+200 > bottom {
+		page = doc.AddPage()
+		y = 60
+	}
+	y += 18
+	for _, total := range inv.totals() {
+		page.TextRight(bold, 10, invoiceColumns[4][0]+invoiceColumns[4][1]-4, y, total[0])
+		page.TextRight(bold, 10, right-4, y, total[1])
+		y += 15
+	}
+	y += 10
+	page.Text(regular, 9, left, y, inv.Summary)
+	y += 14
+	for _, wrapped := range wrapText(bold, 10, inv.AmountInWords, right-left) {
+		page.Text(bold, 10, left, y, wrapped)
+		y += 14
+	}
+	y += 10
+	page.Line(left, y, right, y, 1)
+	y += 35
+	for _, signature := range inv.Signatures {
+		page.Text(regular, 10, left, y, signature)
+		y += 30
+	}
+
+	return doc.Bytes()
+}
+
+// wrapText splits s into lines that fit the width
+func wrapText(font *pdf.Font, size float64, s string, width float64) []string {
+	var lines []string
+	var current string
+	for _, word := range strings.Fields(s) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if current != "" && font.TextWidth(candidate, size) > width {
+			lines = append(lines, current)
+			candidate = word
+		}
+		current = candidate
+	}
+	if current != "" || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func prefixed(prefix, value string) string {
+	if value == "" {
+		return ""
+	}
+	return prefix + value
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, value := range values {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// formatMoney formats an amount as "1 234 567,89"
+func formatMoney(amount float64) string {
+	cents := int64(math.Round(math.Abs(amount) * 100))
+	whole := strconv.FormatInt(cents/100, 10)
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteRune(' ')
+		}
+		grouped.WriteRune(digit)
+	}
+	sign := ""
+	if amount < 0 && cents != 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("%s%s,%02d", sign, grouped.String(), cents%100)
+}
+
+// pluralRU picks the Russian word form for a count: 1 сум, 2 сума, 5 сумов
+func pluralRU(n int64, one, few, many string) string {
+	n %= 100
+	if n >= 11 && n <= 19 {
+		return many
+	}
+	switch n % 10 {
+	case 1:
+		return one
+	case 2, 3, 4:
+		return few
+	}
+	return many
+}
+
+var (
+	ruHundreds   = []string{"", "сто", "двести", "триста", "четыреста", "пятьсот", "шестьсот", "семьсот", "восемьсот", "девятьсот"}
+	ruTens       = []string{"", "", "двадцать", "тридцать", "сорок", "пятьдесят", "шестьдесят", "семьдесят", "восемьдесят", "девяносто"}
+	ruTeens      = []string{"десять", "одиннадцать", "двенадцать", "тринадцать", "четырнадцать", "пятнадцать", "шестнадцать", "семнадцать", "восемнадцать", "девятнадцать"}
+	ruOnes       = []string{"", "один", "два", "три", "четыре", "пять", "шесть", "семь", "восемь", "девять"}
+	ruOnesFemale = []string{"", "одна", "две", "три", "четыре", "пять", "шесть", "семь", "восемь", "девять"}
+	ruScales     = []struct {
+		value          int64
+		one, few, many string
+		female         bool
+	}{
+		{1000000000000, "триллион", "триллиона", "триллионов", false},
+		{1000000000, "миллиард", "миллиарда", "миллиардов", false},
+		{1000000, "миллион", "миллиона", "миллионов", false},
+		{1000, "тысяча", "тысячи", "тысяч", true},
+	}
+)
+
+// triadWordsRU spells 1..999
+func triadWordsRU(n int64, female bool) []string {
+	var words []string
+	if n >= 100 {
+		words = append(words, ruHundreds[n/100])
+	}
+	n %= 100
+	if n >= 10 && n <= 19 {
+		return append(words, ruTeens[n-10])
+	}
+	if n >= 20 {
+		words = append(words, ruTens[n/10])
+	}
+	if n%10 > 0 {
+		if female {
+			words = append(words, ruOnesFemale[n%10])
+		} else {
+			words = append(words, ruOnes[n%10])
+		}
+	}
+	return words
+}
+
+// numberWordsRU spells a whole number in Russian, masculine ("один", "два")
+func numberWordsRU(n int64) string {
+	if n == 0 {
+		return "ноль"
+	}
+	var words []string
+	for _, scale := range ruScales {
+		count := n / scale.value
+		if count == 0 {
+			continue
+		}
+		if count >= 1000 {
+			// Only possible for the largest scale
+			words = append(words, numberWordsRU(count))
+		} else {
+			words = append(words, triadWordsRU(count, scale.female)...)
+		}
+		words = append(words, pluralRU(count, scale.one, scale.few, scale.many))
+		n %= scale.value
+	}
+	words = append(words, triadWordsRU(n, false)...)
+	return strings.Join(words, " ")
+}
+
+// amountInWords spells an amount in sums with tiyins as digits, as printed on invoices:
+// "Одна тысяча двести сумов 50 тийинов"
+func amountInWords(amount float64) string {
+	cents := int64(math.Round(math.Abs(amount) * 100))
+	whole, fraction := cents/100, cents%100
+	words := []rune(numberWordsRU(whole))
+	words[0] = unicode.ToUpper(words[0])
+	return fmt.Sprintf("%s %s %02d %s", string(words), pluralRU(whole, "сум", "сума", "сумов"),
+		fraction, pluralRU(fraction, "тийин", "тийина", "тийинов"))
+}
+
+var (
+	invoiceFontsOnce            sync.Once
+	invoiceRegular, invoiceBold *pdf.Font
+	invoiceFontsErr             error
+)
+
+// invoiceFonts loads the invoice fonts on first use
+func invoiceFonts() (*pdf.Font, *pdf.Font, error) {
+	invoiceFontsOnce.Do(func() {
+		if invoiceRegular, invoiceFontsErr = pdf.LoadFont(invoiceFontPath); invoiceFontsErr != nil {
+			return
+		}
+		invoiceBold, invoiceFontsErr = pdf.LoadFont(invoiceBoldFontPath)
+	})
+	return invoiceRegular, invoiceBold, invoiceFontsErr
+}
+
+// sendInvoice renders the invoice of a legal order as a PDF download
+func sendInvoice(c *fiber.Ctx, order models.Order) error {
+	if order.OrderType != "legal" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invoices are only issued for legal orders",
+		})
+	}
+	if order.Status == models.OrderStatusCancelled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Cannot issue an invoice for a cancelled order",
+		})
+	}
+
+	regular, bold, err := invoiceFonts()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Invoice font is not available: " + err.Error(),
+		})
+	}
+
+	body := renderInvoice(buildInvoice(order, invoiceSellerConfig), regular, bold)
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="invoice-%s.pdf"`, order.Number))
+	return c.Send(body)
+}
+
+// getOrderInvoice - GET /orders/:id/invoice
+func getOrderInvoice(c *fiber.Ctx) error {
+	orderID, err := c.ParamsInt("id")
+	if err != nil || orderID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	var order models.Order
+	if err := db.DB.Preload("OrderItems", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("OrderItems.Product").
+		Where("id = ?", orderID).
+		First(&order).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Order not found",
+		})
+	}
+	return sendInvoice(c, order)
+}
+
+// getUserOrderInvoice - GET /users/:id/orders/:orderId/invoice and GET /me/orders/:orderId/invoice
+func getUserOrderInvoice(c *fiber.Ctx) error {
+	userID, ok := historyUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	orderID, err := c.ParamsInt("orderId")
+	if err != nil || orderID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	var order models.Order
+	if err := db.DB.Preload("OrderItems", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("OrderItems.Product").
+		Where("id = ? AND user_id = ?", orderID, userID).
+		First(&order).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Order not found",
+		})
+	}
+	return sendInvoice(c, order)
+}
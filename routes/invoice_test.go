@@ -0,0 +1,243 @@
+package routes
+
+import (
+	"bytes"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"weldmart/models"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var invoiceTestSeller = invoiceSeller{
+	Name:       "ООО «WeldMart»",
+	INN:        "301234567",
+	Address:    "г. Ташкент, ул. Навои, 1",
+	Phone:      "+998 71 200-00-00",
+	Bank:       "АКБ «Капиталбанк»",
+	Account:    "20208000900123456001",
+	MFO:        "01088",
+	Director:   "Каримов А. А.",
+	Accountant: "Юсупова Н. Б.",
+}
+
+// invoiceTestOrder returns a legal order with the given items and redeemed bonus, priced
+// the way placeOrder stores it
+func invoiceTestOrder(bonus float64, items ...models.OrderItem) models.Order {
+	order := models.Order{
+		Number:       "WM-2026-000123",
+		OrderType:    "legal",
+		Status:       models.OrderStatusNew,
+		Organization: "ООО «Сварка Сервис»",
+		INN:          "305678901",
+		Bonus:        bonus,
+		CreatedAt:    time.Date(2026, time.March, 5, 10, 30, 0, 0, time.UTC),
+	}
+	for i := range items {
+		items[i].LineTotal = roundMoney((items[i].UnitPrice - items[i].UnitDiscount) * float64(items[i].Quantity))
+		order.Subtotal += items[i].LineTotal
+	}
+	order.Price = roundMoney(order.Subtotal - bonus)
+	order.OrderItems = items
+	return order
+}
+
+func invoiceTestItem(id uint, name string, quantity int, price, discount float64) models.OrderItem {
+	return models.OrderItem{
+		ProductID:    id,
+		Quantity:     quantity,
+		UnitPrice:    price,
+		UnitDiscount: discount,
+		Product:      models.Product{ID: id, Name: name},
+	}
+}
+
+func TestBuildInvoiceText(t *testing.T) {
+	tests := []struct {
+		golden string
+		vat    float64
+		order  models.Order
+	}{
+		{
+			golden: "invoice_vat.golden",
+			vat:    12,
+			order: invoiceTestOrder(0,
+				invoiceTestItem(1, "Электроды ESAB OK 46.00 Ø3 мм, пачка 5 кг", 4, 185000, 0),
+				invoiceTestItem(2, "Сварочный инвертор Ресанта САИ-250ПРОФ", 1, 2750000, 275000),
+			),
+		},
+		{
+			golden: "invoice_bonus.golden",
+			vat:    12,
+			order: invoiceTestOrder(35000.5,
+				invoiceTestItem(3, "Маска сварщика хамелеон", 2, 420000, 42000),
+				invoiceTestItem(4, "", 3, 12500, 0),
+			),
+		},
+		{
+			golden: "invoice_no_vat.golden",
+			vat:    0,
+			order: invoiceTestOrder(0,
+				invoiceTestItem(5, "Проволока сварочная СВ-08Г2С 1,2 мм", 1, 1001.21, 0),
+			),
+		},
+		{
+			golden: "invoice_large.golden",
+			vat:    15,
+			order: invoiceTestOrder(0,
+				invoiceTestItem(6, "Сварочный полуавтомат Lincoln Electric Powertec i420C Advanced", 11, 112345678.9, 0),
+			),
+		},
+	}
+
+	defer func(vat float64) { invoiceVATPercent = vat }(invoiceVATPercent)
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			invoiceVATPercent = tt.vat
+			got := buildInvoice(tt.order, invoiceTestSeller).Text()
+
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading golden file (run with -update to create it): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("invoice text differs from %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+func TestAmountInWords(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{0, "Ноль сумов 00 тийинов"},
+		{1, "Один сум 00 тийинов"},
+		{2.01, "Два сума 01 тийин"},
+		{11.12, "Одиннадцать сумов 12 тийинов"},
+		{21.22, "Двадцать один сум 22 тийина"},
+		{1000, "Одна тысяча сумов 00 тийинов"},
+		{2000, "Две тысячи сумов 00 тийинов"},
+		{1200.5, "Одна тысяча двести сумов 50 тийинов"},
+		{5115, "Пять тысяч сто пятнадцать сумов 00 тийинов"},
+		{1000000, "Один миллион сумов 00 тийинов"},
+		{2550000, "Два миллиона пятьсот пятьдесят тысяч сумов 00 тийинов"},
+		{1234567890.99, "Один миллиард двести тридцать четыре миллиона пятьсот шестьдесят семь тысяч восемьсот девяносто сумов 99 тийинов"},
+	}
+	for _, tt := range tests {
+		if got := amountInWords(tt.amount); got != tt.want {
+			t.Errorf("amountInWords(%v) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := map[float64]string{
+		0:          "0,00",
+		5.5:        "5,50",
+		999.999:    "1 000,00",
+		1234567.89: "1 234 567,89",
+		-35000.5:   "-35 000,50",
+	}
+	for amount, want := range tests {
+		if got := formatMoney(amount); got != want {
+			t.Errorf("formatMoney(%v) = %q, want %q", amount, got, want)
+		}
+	}
+}
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`^(\d+) 0 obj\n`)
+	pdfStreamLength = regexp.MustCompile(`/Length (\d+)`)
+	pdfStartXref    = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+)
+
+func TestRenderInvoice(t *testing.T) {
+	regular, bold, err := invoiceFonts()
+	if err != nil {
+		t.Skip("invoice fonts are not installed:", err)
+	}
+
+	// Enough lines to spill onto a second page
+	var items []models.OrderItem
+	for i := 1; i <= 60; i++ {
+		items = append(items, invoiceTestItem(uint(i), "Электрод сварочный УОНИ-13/55 Ø4 мм, пачка "+strconv.Itoa(i)+" кг", i, 1500, 0))
+	}
+	body := renderInvoice(buildInvoice(invoiceTestOrder(1000, items...), invoiceTestSeller), regular, bold)
+
+	if !bytes.HasPrefix(body, []byte("%PDF-1.4\n")) {
+		t.Fatalf("document starts with %q, want %%PDF-1.4", body[:min(len(body), 16)])
+	}
+	match := pdfStartXref.FindSubmatch(body)
+	if match == nil {
+		t.Fatal("document doesn't end with startxref and the end-of-file marker")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if xref >= len(body) || !bytes.HasPrefix(body[xref:], []byte("xref\n0 ")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+
+	// Every object listed in the xref table is where the table says, and every stream
+	// has the declared length and inflates
+	var count int
+	rest := body[xref+len("xref\n0 "):]
+	if _, err := fmt.Sscan(string(rest[:bytes.IndexByte(rest, '\n')]), &count); err != nil {
+		t.Fatal("unreadable xref header:", err)
+	}
+	entries := bytes.Split(rest[bytes.IndexByte(rest, '\n')+1:], []byte("\n"))
+	if len(entries) < count {
+		t.Fatalf("xref table lists %d entries, want %d", len(entries), count)
+	}
+	pages := 0
+	for id := 1; id < count; id++ {
+		offset, err := strconv.Atoi(string(bytes.Fields(entries[id])[0]))
+		if err != nil || offset >= len(body) {
+			t.Fatalf("object %d: bad offset %q", id, entries[id])
+		}
+		object := body[offset:]
+		header := pdfObjectHeader.FindSubmatch(object)
+		if header == nil || string(header[1]) != strconv.Itoa(id) {
+			t.Fatalf("object %d: offset %d doesn't start an object", id, offset)
+		}
+		object = object[:bytes.Index(object, []byte("\nendobj\n"))]
+		if bytes.Contains(object, []byte("/Type /Page ")) {
+			pages++
+		}
+
+		start := bytes.Index(object, []byte(">>\nstream\n"))
+		if start < 0 {
+			continue
+		}
+		length, _ := strconv.Atoi(string(pdfStreamLength.FindSubmatch(object)[1]))
+		data := object[start+len(">>\nstream\n"):]
+		if !bytes.HasSuffix(data, []byte("\nendstream")) || len(data)-len("\nendstream") != length {
+			t.Fatalf("object %d: stream is %d bytes, /Length says %d", id, len(data)-len("\nendstream"), length)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[:length]))
+		if err == nil {
+			_, err = io.Copy(io.Discard, zr)
+		}
+		if err != nil {
+			t.Fatalf("object %d: stream doesn't inflate: %v", id, err)
+		}
+	}
+	if pages < 2 {
+		t.Errorf("rendered %d pages, want the 60 lines to spill onto a second page", pages)
+	}
+}
@@ -0,0 +1,640 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidStatusTransition = errors.New("invalid status transition")
+	errOrderStatusChanged      = errors.New("order status was changed concurrently")
+)
+
+// OrderItemRequest is one line of an order as sent by the client
+type OrderItemRequest struct {
+	ProductID uint `json:"product_id" validate:"required"`
+	Quantity  int  `json:"quantity" validate:"required,gte=1"`
+}
+
+// orderError is a client-facing failure while placing or editing an order
+type orderError struct {
+	Status  int
+	Message string
+}
+
+func (e *orderError) Error() string {
+	return e.Message
+}
+
+// orderActor identifies who changed an order
+type orderActor struct {
+	Type string // "admin", "user", "guest" or "system"
+	ID   uint
+	Name string
+}
+
+// actorFromContext resolves the authenticated admin or customer making the request
+func actorFromContext(c *fiber.Ctx) orderActor {
+	if admin := currentAdmin(c); admin != nil {
+		return orderActor{Type: "admin", ID: admin.ID, Name: admin.Name}
+	}
+	if user := currentUser(c); user != nil {
+		return orderActor{Type: "user", ID: user.ID, Name: user.Name}
+	}
+	return orderActor{Type: "guest"}
+}
+
+// recordOrderStatus appends an entry to the order's status history
+func recordOrderStatus(tx *gorm.DB, orderID uint, from, to, note string, actor orderActor) error {
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:       orderID,
+		Event:         models.OrderEventStatus,
+		FromStatus:    from,
+		ToStatus:      to,
+		ChangedByType: actor.Type,
+		ChangedByID:   actor.ID,
+		ChangedByName: actor.Name,
+		Note:          note,
+	}).Error
+}
+
+// changeOrderStatus moves the order to a new status if the lifecycle allows it, records
+// the change and settles stock and bonus. The update is conditional on the status the order was loaded with, so two
+// concurrent changes cannot both succeed.
+func changeOrderStatus(tx *gorm.DB, order *models.Order, to, note string, actor orderActor) error {
+	if !models.CanTransitionOrderStatus(order.Status, to) {
+		return errInvalidStatusTransition
+	}
+
+	result := tx.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, order.Status).
+		Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errOrderStatusChanged
+	}
+
+	from := order.Status
+	order.Status = to
+	if err := recordOrderStatus(tx, order.ID, from, to, note, actor); err != nil {
+		return err
+	}
+
+	if models.OrderStatusRestocks(to) {
+		if err := restockOrder(tx, order); err != nil {
+			return err
+		}
+	}
+	return settleOrderBonus(tx, order)
+}
+
+// restockOrder returns the order's items to stock. The order is marked as restocked in
+// the same statement that checks it was not, so stock is never given back twice.
+func restockOrder(tx *gorm.DB, order *models.Order) error {
+	now := time.Now()
+	result := tx.Model(&models.Order{}).
+		Where("id = ? AND restocked_at IS NULL", order.ID).
+		Update("restocked_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	order.RestockedAt = &now
+
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := returnStock(tx, item.ProductID, item.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newOrderResponse maps an order with preloaded items (and optionally history) to the API shape
+func newOrderResponse(order models.Order) OrderResponse {
+	orderResponse := OrderResponse{
+		ID:            order.ID,
+		Number:        order.Number,
+		Price:         order.Price,
+		Subtotal:      order.Subtotal,
+		DiscountTotal: order.DiscountTotal,
+		Bonus:         order.Bonus,
+		UserID:        order.UserID,
+		Status:        order.Status,
+		Service:       order.Service,
+		OrderType:     order.OrderType,
+		Phone:         order.Phone,
+		Name:          order.Name,
+		Organization:  order.Organization,
+		INN:           order.INN,
+		Comment:       order.Comment,
+		CreatedAt:     order.CreatedAt,
+		UpdatedAt:     order.UpdatedAt,
+		StatusHistory: order.StatusHistory,
+	}
+
+	for _, item := range order.OrderItems {
+		orderResponse.OrderItems = append(orderResponse.OrderItems, OrderItemResponse{
+			OrderQuantity: item.Quantity,
+			UnitPrice:     item.UnitPrice,
+			UnitDiscount:  item.UnitDiscount,
+			LineTotal:     item.LineTotal,
+			ID:            item.Product.ID,
+			Name:          item.Product.Name,
+			Rating:        item.Product.Rating,
+			Quantity:      item.Product.Quantity,
+			Description:   item.Product.Description,
+			Images:        item.Product.Images,
+			Price:         item.Product.Price,
+			Info:          item.Product.Info,
+			Feature:       item.Product.Feature,
+			Guarantee:     item.Product.Guarantee,
+			Discount:      item.Product.Discount,
+			CreatedAt:     item.Product.CreatedAt,
+			UpdatedAt:     item.Product.UpdatedAt,
+			CategoryID:    item.Product.CategoryID,
+			BrandID:       item.Product.BrandID,
+		})
+	}
+
+	return orderResponse
+}
+
+// roundMoney rounds an amount to whole tiyin
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// parseDiscount turns a product's discount ("10%", "10" or "-10%") into the amount taken
+// off one unit. Discounts are percentages; anything that doesn't parse means no discount.
+func parseDiscount(discount string, unitPrice float64) float64 {
+	value := strings.TrimSpace(discount)
+	value = strings.TrimPrefix(value, "-")
+	value = strings.TrimSuffix(value, "%")
+	percent, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || percent <= 0 {
+		return 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return roundMoney(unitPrice * percent / 100)
+}
+
+// mergeOrderItems sums lines that name the same product into one, in the order the
+// products first appear, so an order holds a single line per product
+func mergeOrderItems(items []OrderItemRequest) []OrderItemRequest {
+	var merged []OrderItemRequest
+	index := make(map[uint]int)
+	for _, item := range items {
+		if i, seen := index[item.ProductID]; seen {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.ProductID] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
+
+// priceOrderItems loads the ordered products, checks stock unless it is already reserved
+// and snapshots their current prices and discounts onto the order items, one per product
+func priceOrderItems(tx *gorm.DB, items []OrderItemRequest, reserved bool) ([]models.OrderItem, error) {
+	var orderItems []models.OrderItem
+	for _, item := range mergeOrderItems(items) {
+		var product models.Product
+		if err := tx.First(&product, item.ProductID).Error; err != nil {
+			return nil, &orderError{fiber.StatusBadRequest, fmt.Sprintf("Product %d not found", item.ProductID)}
+		}
+
+		if !reserved && uint(item.Quantity) > product.Quantity {
+			return nil, &orderError{fiber.StatusBadRequest, fmt.Sprintf("Insufficient quantity for product %d", item.ProductID)}
+		}
+
+		unitDiscount := parseDiscount(product.Discount, product.Price)
+		orderItems = append(orderItems, models.OrderItem{
+			ProductID:    item.ProductID,
+			Quantity:     item.Quantity,
+			UnitPrice:    product.Price,
+			UnitDiscount: unitDiscount,
+			LineTotal:    roundMoney((product.Price - unitDiscount) * float64(item.Quantity)),
+		})
+	}
+	return orderItems, nil
+}
+
+// applyOrderTotals sets the order's subtotal, discount and amount to pay from its items
+// and the bonus being redeemed
+func applyOrderTotals(order *models.Order, items []models.OrderItem) {
+	order.Subtotal, order.DiscountTotal = 0, 0
+	for _, item := range items {
+		order.Subtotal += item.UnitPrice * float64(item.Quantity)
+		order.DiscountTotal += item.UnitDiscount * float64(item.Quantity)
+	}
+	order.Subtotal = roundMoney(order.Subtotal)
+	order.DiscountTotal = roundMoney(order.DiscountTotal)
+	order.Price = roundMoney(order.Subtotal - order.DiscountTotal - order.Bonus)
+}
+
+// placeOrder prices the items from current product data, redeems bonus, takes the items
+// out of stock (or from the given reservation) and stores the order, all in one transaction
+func placeOrder(c *fiber.Ctx, order *models.Order, items []OrderItemRequest, bonus float64, reservationID string) error {
+	tx := db.DB.Begin()
+
+	orderItems, err := priceOrderItems(tx, items, reservationID != "")
+	if err == nil {
+		applyOrderTotals(order, orderItems)
+		order.Bonus, err = redeemableBonus(tx, currentUser(c), bonus, order.Price)
+		applyOrderTotals(order, orderItems)
+	}
+	if err != nil {
+		tx.Rollback()
+		if oe, ok := err.(*orderError); ok {
+			return c.Status(oe.Status).JSON(fiber.Map{
+				"error": oe.Message,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to price order",
+		})
+	}
+
+	if order.Number, err = models.GenerateOrderNumber(tx, time.Now()); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to assign order number",
+		})
+	}
+
+	if err := tx.Create(order).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create " + order.OrderType + " order: " + err.Error(),
+		})
+	}
+
+	if err := recordOrderStatus(tx, order.ID, "", order.Status, "", actorFromContext(c)); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record order status",
+		})
+	}
+
+	if order.Bonus > 0 {
+		if _, err := debitBonus(tx, models.BonusTransaction{
+			UserID:  order.UserID,
+			OrderID: &order.ID,
+			Type:    models.BonusRedemption,
+			Amount:  order.Bonus,
+		}, false); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to redeem bonus",
+			})
+		}
+	}
+
+	for i := range orderItems {
+		orderItems[i].OrderID = order.ID
+	}
+	if err := tx.Create(&orderItems).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create order items: " + err.Error(),
+		})
+	}
+
+	if reservationID != "" {
+		err = consumeReservation(tx, reservationID, order.ID, items)
+	} else {
+		for _, item := range orderItems {
+			if err = takeStock(tx, item.ProductID, item.Quantity); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		if oe, ok := err.(*orderError); ok {
+			return c.Status(oe.Status).JSON(fiber.Map{
+				"error": oe.Message,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update product quantities",
+		})
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to commit transaction",
+		})
+	}
+
+	var fullOrder models.Order
+	if err := db.DB.Preload("OrderItems.Product").First(&fullOrder, order.ID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Order created but failed to load full details",
+		})
+	}
+
+	publishEvent(topicAdmin, EventOrderCreated, newOrderResponse(fullOrder))
+	productIDs := make([]uint, 0, len(orderItems))
+	for _, item := range orderItems {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	publishLowStock(productIDs)
+
+	return c.Status(fiber.StatusCreated).JSON(newOrderResponse(fullOrder))
+}
+
+// Sortable order list columns
+var orderSortColumns = map[string]string{
+	"id":         "orders.id",
+	"created_at": "orders.created_at",
+	"updated_at": "orders.updated_at",
+	"price":      "orders.price",
+	"status":     "orders.status",
+	"number":     "orders.number",
+}
+
+// parseOrderDate accepts a date (2026-01-31) or a timestamp (2026-01-31T10:00:00Z). A bare
+// date as the upper bound covers the whole day.
+func parseOrderDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// applyOrderFilters narrows an order query by the filter query parameters shared by the
+// order list and export: status (comma-separated), order_type, service_mode, user_id,
+// number, phone, inn, organization, date_from and date_to
+func applyOrderFilters(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	if status := c.Query("status"); status != "" {
+		query = query.Where("orders.status IN ?", strings.Split(status, ","))
+	}
+	if orderType := c.Query("order_type"); orderType != "" {
+		if orderType != "individual" && orderType != "legal" {
+			return nil, &orderError{fiber.StatusBadRequest, "order_type must be individual or legal"}
+		}
+		query = query.Where("orders.order_type = ?", orderType)
+	}
+	if service := c.Query("service_mode"); service != "" {
+		query = query.Where("orders.service = ?", service)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := strconv.ParseUint(userID, 10, 64)
+		if err != nil {
+			return nil, &orderError{fiber.StatusBadRequest, "Invalid user_id parameter"}
+		}
+		query = query.Where("orders.user_id = ?", id)
+	}
+	if number := strings.TrimSpace(c.Query("number")); number != "" {
+		query = query.Where("orders.number = ?", strings.ToUpper(number))
+	}
+	if phone := phoneDigits(c.Query("phone")); phone != "" {
+		query = query.Where("REPLACE(REPLACE(REPLACE(orders.phone, '+', ''), ' ', ''), '-', '') LIKE ?", "%"+phone+"%")
+	}
+	if inn := strings.TrimSpace(c.Query("inn")); inn != "" {
+		query = query.Where("orders.inn = ?", inn)
+	}
+	if organization := strings.TrimSpace(c.Query("organization")); organization != "" {
+		query = query.Where("LOWER(orders.organization) LIKE ?", "%"+strings.ToLower(organization)+"%")
+	}
+	if from := c.Query("date_from"); from != "" {
+		t, err := parseOrderDate(from, false)
+		if err != nil {
+			return nil, &orderError{fiber.StatusBadRequest, "Invalid date_from parameter"}
+		}
+		query = query.Where("orders.created_at >= ?", t)
+	}
+	if to := c.Query("date_to"); to != "" {
+		t, err := parseOrderDate(to, true)
+		if err != nil {
+			return nil, &orderError{fiber.StatusBadRequest, "Invalid date_to parameter"}
+		}
+		query = query.Where("orders.created_at <= ?", t)
+	}
+	return query, nil
+}
+
+// applyOrderSort orders the query by the sort (column) and order (asc/desc) query
+// parameters, newest first by default
+func applyOrderSort(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, error) {
+	column, ok := orderSortColumns[c.Query("sort", "created_at")]
+	if !ok {
+		return nil, &orderError{fiber.StatusBadRequest, "Invalid sort parameter"}
+	}
+	direction := strings.ToLower(c.Query("order", "desc"))
+	if direction != "asc" && direction != "desc" {
+		return nil, &orderError{fiber.StatusBadRequest, "order must be asc or desc"}
+	}
+	return query.Order(column + " " + direction).Order("orders.id " + direction), nil
+}
+
+// parsePaging reads skip and limit, defaulting to the first page and capping the page size
+func parsePaging(c *fiber.Ctx, defaultLimit, maxLimit int) (skip, limit int, err error) {
+	skip = c.QueryInt("skip", 0)
+	if skip < 0 {
+		return 0, 0, &orderError{fiber.StatusBadRequest, "Invalid skip parameter"}
+	}
+	limit = c.QueryInt("limit", defaultLimit)
+	if limit <= 0 {
+		return 0, 0, &orderError{fiber.StatusBadRequest, "Invalid limit parameter"}
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return skip, limit, nil
+}
+
+// Item edit operations
+const (
+	itemOpAdd         = "add"          // Add quantity of a product, as a new line if it isn't on the order
+	itemOpRemove      = "remove"       // Drop the product's line
+	itemOpSetQuantity = "set_quantity" // Set the line's quantity; 0 drops it
+)
+
+// OrderItemChange is one edit of an order's items
+type OrderItemChange struct {
+	Op        string `json:"op" validate:"required,oneof=add remove set_quantity"`
+	ProductID uint   `json:"product_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"gte=0"`
+}
+
+// editOrderItems applies item changes to an order that hasn't shipped. Stock follows the
+// quantity changes, lines already on the order keep their snapshot price, new lines are
+// priced from current product data, totals are recalculated and the changes are recorded
+// in the order history.
+func editOrderItems(tx *gorm.DB, order *models.Order, changes []OrderItemChange, note string, actor orderActor) error {
+	if !models.OrderItemsEditable(order.Status) || order.RestockedAt != nil {
+		return &orderError{fiber.StatusConflict, fmt.Sprintf("Items of a %s order can no longer be changed", order.Status)}
+	}
+
+	var items []models.OrderItem
+	if err := tx.Preload("Product").Where("order_id = ?", order.ID).Order("id").Find(&items).Error; err != nil {
+		return err
+	}
+	// Orders placed before duplicate lines were merged may hold a product on several
+	// lines; the first one carries the product's whole quantity and the others are dropped.
+	// Line totals are recomputed from the price snapshots, so an order missing one, or
+	// holding a product at two prices, is left alone rather than repriced by guesswork.
+	lines := make(map[uint]*models.OrderItem)
+	before := make(map[uint]int)
+	stored := make(map[uint]int)
+	for i := range items {
+		productID := items[i].ProductID
+		if items[i].UnitPrice <= 0 && items[i].LineTotal <= 0 {
+			return &orderError{fiber.StatusConflict, fmt.Sprintf("Product %d has no recorded price on this order, so its items can't be changed", productID)}
+		}
+		before[productID] += items[i].Quantity
+		if line := lines[productID]; line != nil {
+			if line.UnitPrice != items[i].UnitPrice || line.UnitDiscount != items[i].UnitDiscount {
+				return &orderError{fiber.StatusConflict, fmt.Sprintf("Product %d is on this order at different prices, so its items can't be changed", productID)}
+			}
+			line.Quantity += items[i].Quantity
+			continue
+		}
+		lines[productID] = &items[i]
+		stored[productID] = items[i].Quantity
+	}
+
+	var added []*models.OrderItem
+	for _, change := range changes {
+		line := lines[change.ProductID]
+		switch change.Op {
+		case itemOpAdd:
+			if change.Quantity < 1 {
+				return &orderError{fiber.StatusBadRequest, "Quantity to add must be at least 1"}
+			}
+			if line != nil {
+				line.Quantity += change.Quantity
+				continue
+			}
+			priced, err := priceOrderItems(tx, []OrderItemRequest{{ProductID: change.ProductID, Quantity: change.Quantity}}, true)
+			if err != nil {
+				return err
+			}
+			line = &priced[0]
+			line.OrderID = order.ID
+			lines[change.ProductID] = line
+			added = append(added, line)
+		case itemOpRemove, itemOpSetQuantity:
+			if line == nil {
+				return &orderError{fiber.StatusBadRequest, fmt.Sprintf("Product %d is not on the order", change.ProductID)}
+			}
+			if change.Op == itemOpRemove {
+				line.Quantity = 0
+			} else {
+				line.Quantity = change.Quantity
+			}
+		}
+	}
+
+	// Move stock by the difference per product; taking more fails if stock ran out
+	var remaining []models.OrderItem
+	var summary []string
+	for productID, line := range lines {
+		delta := line.Quantity - before[productID]
+		if delta > 0 {
+			if err := takeStock(tx, productID, delta); err != nil {
+				return err
+			}
+		} else if delta < 0 {
+			if err := returnStock(tx, productID, -delta); err != nil {
+				return err
+			}
+		}
+		if line.Quantity > 0 {
+			remaining = append(remaining, *line)
+		}
+	}
+	if len(remaining) == 0 {
+		return &orderError{fiber.StatusBadRequest, "An order must keep at least one item; cancel it instead"}
+	}
+
+	for i := range items {
+		line := &items[i]
+		switch {
+		case lines[line.ProductID] != line:
+			// A duplicate line, merged into the product's first line
+			if err := tx.Delete(&models.OrderItem{}, line.ID).Error; err != nil {
+				return err
+			}
+		case line.Quantity == 0:
+			summary = append(summary, fmt.Sprintf("removed product %d", line.ProductID))
+			if err := tx.Delete(&models.OrderItem{}, line.ID).Error; err != nil {
+				return err
+			}
+		case line.Quantity != stored[line.ProductID]:
+			if line.Quantity != before[line.ProductID] {
+				summary = append(summary, fmt.Sprintf("product %d quantity %d → %d", line.ProductID, before[line.ProductID], line.Quantity))
+			}
+			line.LineTotal = roundMoney((line.UnitPrice - line.UnitDiscount) * float64(line.Quantity))
+			if err := tx.Model(&models.OrderItem{}).Where("id = ?", line.ID).Updates(map[string]interface{}{
+				"quantity":   line.Quantity,
+				"line_total": line.LineTotal,
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	for _, line := range added {
+		if line.Quantity == 0 {
+			continue
+		}
+		summary = append(summary, fmt.Sprintf("added product %d × %d", line.ProductID, line.Quantity))
+		line.LineTotal = roundMoney((line.UnitPrice - line.UnitDiscount) * float64(line.Quantity))
+		if err := tx.Omit("Product").Create(line).Error; err != nil {
+			return err
+		}
+	}
+	if len(summary) == 0 {
+		return nil
+	}
+
+	applyOrderTotals(order, remaining)
+	if err := trimOrderBonus(tx, order); err != nil {
+		return err
+	}
+	applyOrderTotals(order, remaining)
+
+	if note != "" {
+		summary = append(summary, note)
+	}
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:       order.ID,
+		Event:         models.OrderEventItems,
+		FromStatus:    order.Status,
+		ToStatus:      order.Status,
+		ChangedByType: actor.Type,
+		ChangedByID:   actor.ID,
+		ChangedByName: actor.Name,
+		Note:          strings.Join(summary, "; "),
+	}).Error
+}
@@ -0,0 +1,367 @@
+package routes
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMergeOrderItems(t *testing.T) {
+	merged := mergeOrderItems([]OrderItemRequest{
+		{ProductID: 7, Quantity: 2},
+		{ProductID: 3, Quantity: 1},
+		{ProductID: 7, Quantity: 3},
+	})
+	want := []OrderItemRequest{{ProductID: 7, Quantity: 5}, {ProductID: 3, Quantity: 1}}
+	if len(merged) != len(want) {
+		t.Fatalf("merged into %v, want %v", merged, want)
+	}
+	for i := range want {
+		if merged[i] != want[i] {
+			t.Fatalf("merged into %v, want %v", merged, want)
+		}
+	}
+}
+
+func TestPriceOrderItemsMergesDuplicateProducts(t *testing.T) {
+	openTestDB(t)
+	product := createTestProduct(t, "Электроды", 10, 100)
+
+	items, err := priceOrderItems(db.DB, []OrderItemRequest{
+		{ProductID: product.ID, Quantity: 2},
+		{ProductID: product.ID, Quantity: 3},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Quantity != 5 || items[0].LineTotal != 500 {
+		t.Fatalf("priced %+v, want one line of 5 units for 500", items)
+	}
+
+	// The summed quantity is checked against stock, not each line on its own
+	if _, err := priceOrderItems(db.DB, []OrderItemRequest{
+		{ProductID: product.ID, Quantity: 6},
+		{ProductID: product.ID, Quantity: 6},
+	}, false); err == nil {
+		t.Error("priced 12 units of a product with 10 in stock")
+	}
+}
+
+// createLegacyOrder stores an order the way it was stored before item prices were
+// snapshotted: no subtotal and no prices on the lines
+func createLegacyOrder(t *testing.T, number string, price float64, lines map[uint]int) models.Order {
+	t.Helper()
+	order := models.Order{Number: number, Status: models.OrderStatusNew, OrderType: "individual", Price: price}
+	if err := db.DB.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	for productID, quantity := range lines {
+		line := models.OrderItem{OrderID: order.ID, ProductID: productID, Quantity: quantity}
+		if err := db.DB.Create(&line).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return order
+}
+
+func TestMigrateSnapshotsLegacyOrderPrices(t *testing.T) {
+	openTestDB(t)
+	wire := createTestProduct(t, "Проволока", 20, 100)
+	mask := createTestProduct(t, "Маска", 5, 50)
+
+	// The price charged back then is spread over the lines in proportion to product prices
+	charged := createLegacyOrder(t, "WM-2026-000001", 300, map[uint]int{wire.ID: 1, mask.ID: 2})
+	// Without a recorded price the product prices are taken as they are
+	unpriced := createLegacyOrder(t, "WM-2026-000002", 0, map[uint]int{wire.ID: 2})
+	// When a product is gone the price is split evenly per unit
+	gone := createLegacyOrder(t, "WM-2026-000003", 90, map[uint]int{wire.ID: 1, 9999: 2})
+
+	db.Migrate()
+
+	for _, test := range []struct {
+		order     models.Order
+		subtotal  float64
+		price     float64
+		lineTotal map[uint]float64
+	}{
+		{charged, 300, 300, map[uint]float64{wire.ID: 150, mask.ID: 150}},
+		{unpriced, 200, 200, map[uint]float64{wire.ID: 200}},
+		{gone, 90, 90, map[uint]float64{wire.ID: 30, 9999: 60}},
+	} {
+		var order models.Order
+		db.DB.Preload("OrderItems").First(&order, test.order.ID)
+		if order.Subtotal != test.subtotal || order.Price != test.price {
+			t.Errorf("order %s: subtotal %v and price %v, want %v and %v", order.Number, order.Subtotal, order.Price, test.subtotal, test.price)
+		}
+		for _, item := range order.OrderItems {
+			if item.LineTotal != test.lineTotal[item.ProductID] || item.UnitPrice*float64(item.Quantity) != item.LineTotal {
+				t.Errorf("order %s: line %+v, want a total of %v", order.Number, item, test.lineTotal[item.ProductID])
+			}
+		}
+	}
+}
+
+// An order placed before lines were merged can hold one product on two lines; editing
+// another product must neither move its stock nor lose one of its lines
+func TestEditOrderItemsWithDuplicateLines(t *testing.T) {
+	openTestDB(t)
+	wire := createTestProduct(t, "Проволока", 20, 100)
+	mask := createTestProduct(t, "Маска", 5, 50)
+
+	order := models.Order{Number: "WM-2026-000001", Status: models.OrderStatusNew, OrderType: "individual", Subtotal: 500, Price: 500}
+	if err := db.DB.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, quantity := range []int{2, 3} {
+		line := models.OrderItem{OrderID: order.ID, ProductID: wire.ID, Quantity: quantity, UnitPrice: 100, LineTotal: float64(quantity) * 100}
+		if err := db.DB.Create(&line).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := db.DB.Begin()
+	err := editOrderItems(tx, &order, []OrderItemChange{{Op: itemOpAdd, ProductID: mask.ID, Quantity: 1}}, "", orderActor{Type: "system"})
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := productQuantity(t, wire.ID); got != 20 {
+		t.Errorf("wire stock is %d after editing another product, want 20", got)
+	}
+	if got := productQuantity(t, mask.ID); got != 4 {
+		t.Errorf("mask stock is %d, want 4", got)
+	}
+	if order.Price != 550 {
+		t.Errorf("order price is %v, want 550", order.Price)
+	}
+
+	var lines []models.OrderItem
+	db.DB.Where("order_id = ? AND product_id = ?", order.ID, wire.ID).Find(&lines)
+	if len(lines) != 1 || lines[0].Quantity != 5 || lines[0].LineTotal != 500 {
+		t.Errorf("wire lines are %+v, want one line of 5 units for 500", lines)
+	}
+
+	// Lowering the merged quantity returns only the difference
+	tx = db.DB.Begin()
+	err = editOrderItems(tx, &order, []OrderItemChange{{Op: itemOpSetQuantity, ProductID: wire.ID, Quantity: 4}}, "", orderActor{Type: "system"})
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	tx.Commit()
+	if got := productQuantity(t, wire.ID); got != 21 {
+		t.Errorf("wire stock is %d after lowering 5 → 4, want 21", got)
+	}
+	if order.Price != 450 {
+		t.Errorf("order price is %v, want 450", order.Price)
+	}
+}
+
+// editItems runs editOrderItems in a transaction of its own
+func editItems(order *models.Order, changes ...OrderItemChange) error {
+	tx := db.DB.Begin()
+	if err := editOrderItems(tx, order, changes, "", orderActor{Type: "system"}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// An order without price snapshots can't be repriced from them; once the migration has
+// filled them in it can be edited again
+func TestEditOrderItemsNeedsPriceSnapshots(t *testing.T) {
+	openTestDB(t)
+	wire := createTestProduct(t, "Проволока", 20, 100)
+	mask := createTestProduct(t, "Маска", 5, 50)
+	order := createLegacyOrder(t, "WM-2026-000001", 300, map[uint]int{wire.ID: 3})
+
+	err := editItems(&order, OrderItemChange{Op: itemOpAdd, ProductID: mask.ID, Quantity: 1})
+	if oe, ok := err.(*orderError); !ok || oe.Status != fiber.StatusConflict {
+		t.Fatalf("editing an order without price snapshots returned %v, want a conflict", err)
+	}
+	if got := productQuantity(t, mask.ID); got != 5 {
+		t.Errorf("mask stock is %d after a refused edit, want 5", got)
+	}
+	var stored models.Order
+	db.DB.First(&stored, order.ID)
+	if stored.Price != 300 {
+		t.Errorf("order price is %v after a refused edit, want 300", stored.Price)
+	}
+
+	db.Migrate()
+	db.DB.First(&order, order.ID)
+	if err := editItems(&order, OrderItemChange{Op: itemOpAdd, ProductID: mask.ID, Quantity: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if order.Price != 350 {
+		t.Errorf("order price is %v, want 350", order.Price)
+	}
+}
+
+func TestEditOrderItemsRefusesDuplicatesAtDifferentPrices(t *testing.T) {
+	openTestDB(t)
+	wire := createTestProduct(t, "Проволока", 20, 100)
+	mask := createTestProduct(t, "Маска", 5, 50)
+
+	order := models.Order{Number: "WM-2026-000001", Status: models.OrderStatusNew, OrderType: "individual", Subtotal: 290, Price: 290}
+	if err := db.DB.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range []float64{100, 90} {
+		line := models.OrderItem{OrderID: order.ID, ProductID: wire.ID, Quantity: 1, UnitPrice: price, LineTotal: price}
+		if err := db.DB.Create(&line).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := editItems(&order, OrderItemChange{Op: itemOpAdd, ProductID: mask.ID, Quantity: 1})
+	if oe, ok := err.(*orderError); !ok || oe.Status != fiber.StatusConflict {
+		t.Fatalf("merging lines at different prices returned %v, want a conflict", err)
+	}
+	var lines int64
+	db.DB.Model(&models.OrderItem{}).Where("order_id = ?", order.ID).Count(&lines)
+	if lines != 2 {
+		t.Errorf("order has %d lines after a refused edit, want 2", lines)
+	}
+}
+
+// createTestOrder stores an order for quantity units of the product in the given status
+// and takes them out of stock the way placing it would have
+func createTestOrder(t *testing.T, status string, product models.Product, quantity int) models.Order {
+	t.Helper()
+	total := product.Price * float64(quantity)
+	order := models.Order{Status: status, OrderType: "individual", Name: "Customer", Phone: "+998901234567", Subtotal: total, Price: total}
+	var err error
+	if order.Number, err = models.GenerateOrderNumber(db.DB, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	item := models.OrderItem{OrderID: order.ID, ProductID: product.ID, Quantity: quantity, UnitPrice: product.Price, LineTotal: total}
+	if err := db.DB.Create(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := takeStock(db.DB, product.ID, quantity); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// runConcurrently starts all calls at once and waits for them to finish
+func runConcurrently(calls ...func()) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for _, call := range calls {
+		done.Add(1)
+		go func(call func()) {
+			defer done.Done()
+			start.Wait()
+			call()
+		}(call)
+	}
+	start.Done()
+	done.Wait()
+}
+
+// Cancelling, returning and deleting the same order at the same time, or deleting it
+// after it was cancelled or returned, must put its items back in stock exactly once
+func TestConcurrentRestockHappensOnce(t *testing.T) {
+	const parallel = 8
+
+	tests := []struct {
+		name   string
+		status string // Of the order before the requests
+		to     string // Status the updates move it to
+	}{
+		{"cancel new", models.OrderStatusNew, models.OrderStatusCancelled},
+		{"cancel packed", models.OrderStatusPacked, models.OrderStatusCancelled},
+		{"return shipped", models.OrderStatusShipped, models.OrderStatusReturned},
+		{"return delivered", models.OrderStatusDelivered, models.OrderStatusReturned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newConcurrentTestApp(t)
+			token := testAdminToken(t, models.RoleOwner)
+			product := createTestProduct(t, "Электроды", 10, 100)
+			path := func(order models.Order) string { return fmt.Sprintf("/api/orders/%d", order.ID) }
+			body := func(order models.Order) string { return fmt.Sprintf(`{"id": %d, "status": %q}`, order.ID, tt.to) }
+
+			// Status changes racing each other
+			order := createTestOrder(t, tt.status, product, 3)
+			var mu sync.Mutex
+			var statuses []int
+			var calls []func()
+			for i := 0; i < parallel; i++ {
+				calls = append(calls, func() {
+					status := requestJSON(t, app, "PUT", path(order), token, body(order))
+					mu.Lock()
+					statuses = append(statuses, status)
+					mu.Unlock()
+				})
+			}
+			runConcurrently(calls...)
+
+			if got := productQuantity(t, product.ID); got != 10 {
+				t.Errorf("stock is %d after concurrent updates %v, want 10", got, statuses)
+			}
+			var stored models.Order
+			if err := db.DB.First(&stored, order.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.to || stored.RestockedAt == nil {
+				t.Errorf("order is %s with restocked_at %v, want %s and set", stored.Status, stored.RestockedAt, tt.to)
+			}
+			// Requests that find the order already moved are no-ops, so the change is recorded once
+			var changes int64
+			db.DB.Model(&models.OrderStatusHistory{}).Where("order_id = ? AND to_status = ?", order.ID, tt.to).Count(&changes)
+			if changes != 1 {
+				t.Errorf("history records %d changes to %s (%v), want 1", changes, tt.to, statuses)
+			}
+			for _, status := range statuses {
+				if status != fiber.StatusOK && status != fiber.StatusConflict {
+					t.Errorf("status change failed with %d", status)
+				}
+			}
+
+			// Deleting the restocked order doesn't give its items back again
+			calls = nil
+			for i := 0; i < parallel; i++ {
+				calls = append(calls, func() { request(t, app, "DELETE", path(order), token) })
+			}
+			runConcurrently(calls...)
+			if got := productQuantity(t, product.ID); got != 10 {
+				t.Errorf("stock is %d after deleting the restocked order, want 10", got)
+			}
+			if err := db.DB.First(&models.Order{}, order.ID).Error; err == nil {
+				t.Error("order still exists after deletes")
+			}
+
+			// Status changes and deletes racing each other
+			order = createTestOrder(t, tt.status, product, 4)
+			calls = nil
+			for i := 0; i < parallel; i++ {
+				calls = append(calls,
+					func() { requestJSON(t, app, "PUT", path(order), token, body(order)) },
+					func() { request(t, app, "DELETE", path(order), token) },
+				)
+			}
+			runConcurrently(calls...)
+			got := productQuantity(t, product.ID)
+			// A delivered order deleted before it was returned stays with the customer
+			if got != 10 && !(tt.status == models.OrderStatusDelivered && got == 6) {
+				t.Errorf("stock is %d after racing updates and deletes, want 10", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// How long reserved stock is held for a customer who is paying
+	reservationTTL = time.Duration(envInt("RESERVATION_TTL_MINUTES", 15)) * time.Minute
+	// Most units, over all products, one reservation may hold
+	reservationMaxQuantity = envInt("RESERVATION_MAX_QUANTITY", 50)
+	// Reservations take real stock without an order, so limit how fast one client can make them
+	reservationLimiter = newRateLimiter(envInt("RESERVATION_RATE_LIMIT", 5), time.Minute)
+)
+
+// ReservationRequest lists the products to hold
+type ReservationRequest struct {
+	OrderItems []OrderItemRequest `json:"order_items" validate:"required,min=1,dive"`
+}
+
+// takeStock removes quantity units of a product from stock. The decrement is conditional
+// on enough stock being left, so parallel orders can never take more than there is.
+func takeStock(tx *gorm.DB, productID uint, quantity int) error {
+	result := tx.Model(&models.Product{}).
+		Where("id = ? AND quantity >= ?", productID, quantity).
+		Update("quantity", gorm.Expr("quantity - ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &orderError{fiber.StatusConflict, fmt.Sprintf("Insufficient quantity for product %d", productID)}
+	}
+	return nil
+}
+
+// returnStock puts quantity units of a product back in stock
+func returnStock(tx *gorm.DB, productID uint, quantity int) error {
+	return tx.Model(&models.Product{}).
+		Where("id = ?", productID).
+		Update("quantity", gorm.Expr("quantity + ?", quantity)).Error
+}
+
+// itemQuantities sums the ordered quantity per product
+func itemQuantities(items []OrderItemRequest) map[uint]int {
+	quantities := make(map[uint]int)
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	return quantities
+}
+
+// consumeReservation hands the stock held by a reservation over to an order. The order
+// must contain exactly the reserved products and quantities.
+func consumeReservation(tx *gorm.DB, token string, orderID uint, items []OrderItemRequest) error {
+	var reservation models.StockReservation
+	if err := tx.Preload("Items").Where("token = ?", token).First(&reservation).Error; err != nil {
+		return &orderError{fiber.StatusNotFound, "Reservation not found"}
+	}
+
+	reserved := make(map[uint]int)
+	for _, item := range reservation.Items {
+		reserved[item.ProductID] += item.Quantity
+	}
+	ordered := itemQuantities(items)
+	if len(reserved) != len(ordered) {
+		return &orderError{fiber.StatusBadRequest, "Order items do not match the reservation"}
+	}
+	for productID, quantity := range ordered {
+		if reserved[productID] != quantity {
+			return &orderError{fiber.StatusBadRequest, "Order items do not match the reservation"}
+		}
+	}
+
+	now := time.Now()
+	result := tx.Model(&models.StockReservation{}).
+		Where("id = ? AND consumed_at IS NULL AND released_at IS NULL AND expires_at > ?", reservation.ID, now).
+		Updates(map[string]interface{}{"consumed_at": now, "order_id": orderID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &orderError{fiber.StatusConflict, "Reservation has expired or was already used"}
+	}
+	return nil
+}
+
+// releaseReservation gives the reserved stock back unless the reservation was already
+// consumed or released. It reports whether anything was released.
+func releaseReservation(tx *gorm.DB, reservation *models.StockReservation) (bool, error) {
+	now := time.Now()
+	result := tx.Model(&models.StockReservation{}).
+		Where("id = ? AND consumed_at IS NULL AND released_at IS NULL", reservation.ID).
+		Update("released_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	reservation.ReleasedAt = &now
+
+	var items []models.StockReservationItem
+	if err := tx.Where("reservation_id = ?", reservation.ID).Find(&items).Error; err != nil {
+		return false, err
+	}
+	for _, item := range items {
+		if err := returnStock(tx, item.ProductID, item.Quantity); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// releaseExpiredReservations gives back the stock of reservations nobody paid for in time
+func releaseExpiredReservations() error {
+	var reservations []models.StockReservation
+	if err := db.DB.
+		Where("consumed_at IS NULL AND released_at IS NULL AND expires_at <= ?", time.Now()).
+		Find(&reservations).Error; err != nil {
+		return err
+	}
+	for i := range reservations {
+		if err := db.DB.Transaction(func(tx *gorm.DB) error {
+			_, err := releaseReservation(tx, &reservations[i])
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runReservationExpiry periodically releases expired reservations
+func runReservationExpiry(interval time.Duration) {
+	for {
+		if err := releaseExpiredReservations(); err != nil {
+			log.Printf("Reservation expiry failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// createReservation - POST /reservations
+func createReservation(c *fiber.Ctx) error {
+	var req ReservationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	if err := validate.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	var total int
+	for _, item := range req.OrderItems {
+		total += item.Quantity
+	}
+	if total > reservationMaxQuantity {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("A reservation may hold at most %d units", reservationMaxQuantity),
+		})
+	}
+
+	reservation := models.StockReservation{
+		Token:     uuid.New().String(),
+		ExpiresAt: time.Now().Add(reservationTTL),
+	}
+	if user := currentUser(c); user != nil {
+		reservation.UserID = user.ID
+	}
+	for productID, quantity := range itemQuantities(req.OrderItems) {
+		reservation.Items = append(reservation.Items, models.StockReservationItem{
+			ProductID: productID,
+			Quantity:  quantity,
+		})
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range reservation.Items {
+			if err := takeStock(tx, item.ProductID, item.Quantity); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&reservation).Error
+	})
+	if oe, ok := err.(*orderError); ok {
+		return c.Status(oe.Status).JSON(fiber.Map{
+			"error": oe.Message,
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reserve stock",
+		})
+	}
+
+	productIDs := make([]uint, 0, len(reservation.Items))
+	for _, item := range reservation.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	publishLowStock(productIDs)
+
+	return c.Status(fiber.StatusCreated).JSON(reservation)
+}
+
+// getReservation - GET /reservations/:token
+func getReservation(c *fiber.Ctx) error {
+	var reservation models.StockReservation
+	if err := db.DB.Preload("Items").Where("token = ?", c.Params("token")).First(&reservation).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Reservation not found",
+		})
+	}
+	return c.JSON(reservation)
+}
+
+// deleteReservation - DELETE /reservations/:token
+func deleteReservation(c *fiber.Ctx) error {
+	var reservation models.StockReservation
+	if err := db.DB.Where("token = ?", c.Params("token")).First(&reservation).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Reservation not found",
+		})
+	}
+
+	var released bool
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		released, err = releaseReservation(tx, &reservation)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to release reservation",
+		})
+	}
+	if !released {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Reservation was already used or released",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Reservation released successfully",
+	})
+}
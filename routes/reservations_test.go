@@ -0,0 +1,94 @@
+package routes
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Parallel orders for more units than are in stock sell exactly the stock and never
+// take the product below zero
+func TestParallelOrdersNeverOversell(t *testing.T) {
+	const stock, buyers = 7, 40
+
+	app := newConcurrentTestApp(t)
+	product := createTestProduct(t, "Инвертор", stock, 1000)
+	body := fmt.Sprintf(`{"service_mode": "pickup", "phone": "+998901234567", "name": "Buyer",
+		"order_items": [{"product_id": %d, "quantity": 1}]}`, product.ID)
+
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	var calls []func()
+	for i := 0; i < buyers; i++ {
+		calls = append(calls, func() {
+			status := requestJSON(t, app, "POST", "/api/individual-orders", "", body)
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		})
+	}
+	runConcurrently(calls...)
+
+	if statuses[fiber.StatusCreated] != stock {
+		t.Errorf("%d orders were placed (%v), want %d", statuses[fiber.StatusCreated], statuses, stock)
+	}
+	for status, count := range statuses {
+		if status != fiber.StatusCreated && status != fiber.StatusBadRequest && status != fiber.StatusConflict {
+			t.Errorf("%d orders failed with status %d", count, status)
+		}
+	}
+	// Quantity is unsigned, so going below zero would show up as a huge number
+	if got := productQuantity(t, product.ID); got != 0 {
+		t.Errorf("stock is %d, want 0", got)
+	}
+	var ordered int64
+	db.DB.Model(&models.OrderItem{}).Where("product_id = ?", product.ID).Select("COALESCE(SUM(quantity), 0)").Scan(&ordered)
+	if ordered != stock {
+		t.Errorf("orders hold %d units, want %d", ordered, stock)
+	}
+}
+
+// resetReservationLimiter forgets earlier reservations so each test starts with a full budget
+func resetReservationLimiter() {
+	reservationLimiter.mutex.Lock()
+	reservationLimiter.hits = make(map[string][]time.Time)
+	reservationLimiter.mutex.Unlock()
+}
+
+func TestReservationLimits(t *testing.T) {
+	app := newTestApp(t)
+	resetReservationLimiter()
+	t.Cleanup(resetReservationLimiter)
+	product := createTestProduct(t, "Электроды", 1000, 100)
+
+	reserve := func(quantity int) int {
+		return requestJSON(t, app, "POST", "/api/reservations", "",
+			fmt.Sprintf(`{"order_items": [{"product_id": %d, "quantity": %d}]}`, product.ID, quantity))
+	}
+
+	if status := reserve(reservationMaxQuantity + 1); status != fiber.StatusBadRequest {
+		t.Errorf("reserving %d units: status %d, want 400", reservationMaxQuantity+1, status)
+	}
+	if got := productQuantity(t, product.ID); got != 1000 {
+		t.Errorf("stock is %d after a rejected reservation, want 1000", got)
+	}
+
+	// The rejected request counted against the limit too
+	for i := 1; i < reservationLimiter.max; i++ {
+		if status := reserve(1); status != fiber.StatusCreated {
+			t.Fatalf("reservation %d: status %d, want 201", i, status)
+		}
+	}
+	if status := reserve(1); status != fiber.StatusTooManyRequests {
+		t.Errorf("reservation over the rate limit: status %d, want 429", status)
+	}
+	if got, want := productQuantity(t, product.ID), uint(1000-(reservationLimiter.max-1)); got != want {
+		t.Errorf("stock is %d, want %d", got, want)
+	}
+}
@@ -1,12 +1,11 @@
 package routes
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"path/filepath"
 	"regexp"
-	"sync"
+	"strings"
 	"time"
 
 	"weldmart/db"
@@ -16,26 +15,17 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Adjust this for production
-	},
-}
-
-// Connected clients map with mutex for thread safety
-var clients = make(map[*websocket.Conn]bool)
-var broadcast = make(chan []byte, 100) // Buffered channel to prevent blocking
-var mutex = &sync.Mutex{}
 var validate = validator.New()
 
 type OrderItemResponse struct {
 	OrderQuantity int       `json:"order_quantity"`
+	UnitPrice     float64   `json:"unit_price"`    // Price per unit when the order was placed
+	UnitDiscount  float64   `json:"unit_discount"` // Discount per unit when the order was placed
+	LineTotal     float64   `json:"line_total"`
 	ID            uint      `json:"id"`
 	Name          string    `json:"name"`
 	Rating        float64   `json:"rating"`
@@ -54,21 +44,26 @@ type OrderItemResponse struct {
 }
 
 type OrderResponse struct {
-	ID           uint                `json:"id"`
-	Price        float64             `json:"price"`
-	Bonus        float64             `json:"bonus"`
-	UserID       uint                `json:"user_id"`
-	OrderType    string              `json:"order_type"`
-	Status       string              `json:"status"`
-	Service      string              `json:"service_mode"`
-	Phone        string              `json:"phone,omitempty"`
-	Name         string              `json:"name,omitempty"`
-	Organization string              `json:"organization,omitempty"`
-	INN          string              `json:"inn,omitempty"`
-	Comment      string              `json:"comment,omitempty"`
-	CreatedAt    time.Time           `json:"created_at"`
-	UpdatedAt    time.Time           `json:"updated_at"`
-	OrderItems   []OrderItemResponse `json:"order_items"`
+	ID            uint                `json:"id"`
+	Number        string              `json:"number"`
+	Price         float64             `json:"price"`
+	Subtotal      float64             `json:"subtotal"`
+	DiscountTotal float64             `json:"discount_total"`
+	Bonus         float64             `json:"bonus"`
+	UserID        uint                `json:"user_id"`
+	OrderType     string              `json:"order_type"`
+	Status        string              `json:"status"`
+	Service       string              `json:"service_mode"`
+	Phone         string              `json:"phone,omitempty"`
+	Name          string              `json:"name,omitempty"`
+	Organization  string              `json:"organization,omitempty"`
+	INN           string              `json:"inn,omitempty"`
+	Comment       string              `json:"comment,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+	OrderItems    []OrderItemResponse `json:"order_items"`
+
+	StatusHistory []models.OrderStatusHistory `json:"status_history,omitempty"`
 }
 
 type ProductResponse struct {
@@ -83,10 +78,39 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// UserRequest is the writable part of a user; the password is accepted here but never returned
+// The bonus balance is not writable; it follows the bonus ledger.
+type UserRequest struct {
+	Name      string `json:"name"`
+	Phone     string `json:"phone"`
+	Password  string `json:"password"`
+	RassikaID uint   `json:"rassika_id"`
+}
+
+type AdminRequest struct {
+	Name     string `json:"name"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type AdminLoginRequest struct {
+	Login    string `json:"login" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type AdminLoginResponse struct {
+	Message     string       `json:"message"`
+	Admin       models.Admin `json:"admin"`
+	Permissions []string     `json:"permissions"`
+	TokenPair
+}
+
 // LoginResponse defines the structure of the login response
 type LoginResponse struct {
 	Message string      `json:"message"`
 	User    models.User `json:"user"` // Full user details
+	TokenPair
 }
 
 type CategoryWithProductsResponse struct {
@@ -144,10 +168,6 @@ type BrandListResponse struct {
 	Limit  int             `json:"limit"`
 }
 
-type SearchResponse struct {
-	Products []models.Product `json:"products"`
-}
-
 type Category struct {
 	ID   uint   `gorm:"primaryKey" json:"id"`
 	Name string `json:"name"`
@@ -221,94 +241,103 @@ type CategoryWithBottomCategoriesResponse struct {
 
 func SetupRoutes(app *fiber.App) {
 
-	wsHandler := adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Println("Error upgrading:", err)
-			return
-		}
-		defer conn.Close()
-
-		mutex.Lock()
-		clients[conn] = true
-		mutex.Unlock()
-		log.Println("Client connected:", conn.RemoteAddr())
-
-		for {
-			_, message, err := conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket read error: %v", err)
-				}
-				mutex.Lock()
-				delete(clients, conn)
-				mutex.Unlock()
-				log.Println("Client disconnected:", conn.RemoteAddr())
-				break
-			}
-			log.Printf("Received message from %v: %s", conn.RemoteAddr(), string(message))
-			broadcast <- message
-		}
-	})
+	// Write off expired bonus points
+	go runBonusExpiry(time.Hour)
 
-	// Handle broadcasting messages to all clients
-	go func() {
-		for message := range broadcast {
-			mutex.Lock()
-			for client := range clients {
-				err := client.WriteMessage(websocket.TextMessage, message)
-				if err != nil {
-					log.Printf("WebSocket write error: %v", err)
-					client.Close()
-					delete(clients, client)
-				}
-			}
-			mutex.Unlock()
-		}
-	}()
+	// Give back stock held by reservations that were never paid for
+	go runReservationExpiry(time.Minute)
 
+	// The WebSocket hub shares messages with other instances
+	hub.start()
+
+	mountRoutes(app)
+}
+
+// mountRoutes registers the HTTP handlers without starting the background jobs and the
+// WebSocket hub
+func mountRoutes(app *fiber.App) {
 	// Mount WebSocket endpoint
-	app.Get("/ws", wsHandler)
+	app.Get("/ws", adaptor.HTTPHandlerFunc(serveWS))
 	// Image upload route
-	app.Post("/upload", uploadImage)
+	app.Post("/upload", requireAdmin, uploadImage)
 
 	// User routes
 	api := app.Group("/api")
 
-	admin := api.Group("/admin")
-	admin.Post("/", createAdmin)
-	admin.Put("/", updateAdmin)
-	admin.Get("/", getAdmin)
-	
+	// Per-permission guards for admin-only routes. Catalog and CMS groups mount
+	// adminWrites instead, which keeps their GET routes public.
+	canManageOrders := requirePermission(models.PermissionOrders)
+	canManageContent := requirePermission(models.PermissionContent)
+	canManageUsers := requirePermission(models.PermissionUsers)
+	canManageAdmins := requirePermission(models.PermissionAdmins)
 
-	priceSwitch := api.Group("/price-switch")
+	admin := api.Group("/admin")
+	admin.Post("/login", adminLoginHandler)
+	admin.Post("/refresh", adminRefreshHandler)
+	admin.Post("/logout", adminLogoutHandler)
+	admin.Get("/me", requireAdmin, getAdmin)
+	admin.Put("/", requireAdmin, updateAdmin)
+	admin.Post("/", requireFirstAdminOrPermission, createAdmin)
+	admin.Get("/", canManageAdmins, getAllAdmins)
+	admin.Put("/:id", canManageAdmins, updateAdminByID)
+	admin.Delete("/:id", canManageAdmins, deleteAdmin)
+
+	priceSwitch := api.Group("/price-switch", adminWrites(models.PermissionPriceSwitch))
     priceSwitch.Get("/", getPriceSwitch)
     priceSwitch.Put("/", updatePriceSwitch)
 
 	api.Post("/login", loginHandler)
+	api.Post("/refresh", refreshHandler)
+	api.Post("/logout", logoutHandler)
 
 	users := api.Group("/users")
 	users.Post("/", createUser)
-	users.Get("/", getAllUsers)
-	users.Get("/:id", getUser)
-	users.Put("/:id", updateUser)
-	users.Delete("/:id", deleteUser)
-
-	stats := api.Group("/statistics")
+	users.Get("/", canManageUsers, getAllUsers)
+	users.Get("/:id", requireSelfOrPermission(models.PermissionUsers), getUser)
+	users.Put("/:id", requireSelfOrPermission(models.PermissionUsers), updateUser)
+	users.Delete("/:id", canManageUsers, deleteUser)
+	users.Get("/:id/bonus", requireSelfOrPermission(models.PermissionUsers), getUserBonus)
+	users.Post("/:id/bonus/adjustments", canManageUsers, adjustUserBonus)
+	users.Get("/:id/orders", requireSelfOrPermission(models.PermissionOrders), getUserOrders)
+	users.Get("/:id/orders/:orderId", requireSelfOrPermission(models.PermissionOrders), getUserOrder)
+	users.Get("/:id/orders/:orderId/invoice", requireSelfOrPermission(models.PermissionOrders), getUserOrderInvoice)
+
+	// The signed-in customer's own resources
+	me := api.Group("/me", requireUser)
+	me.Get("/orders", getUserOrders)
+	me.Get("/orders/:orderId", getUserOrder)
+	me.Get("/orders/:orderId/invoice", getUserOrderInvoice)
+
+	// Support chat: customers (signed in, or guests with X-Support-Token) use their own
+	// conversation, admins go through all of them
+	support := api.Group("/support")
+	support.Post("/conversation", supportConversationLimiter, optionalUser, startSupportConversation)
+	support.Get("/conversation", optionalUser, getSupportConversation)
+	support.Get("/conversation/messages", optionalUser, getSupportMessages)
+	support.Post("/conversation/messages", optionalUser, sendSupportMessage)
+	support.Post("/conversation/read", optionalUser, readSupportMessages)
+	support.Get("/conversations", canManageUsers, getSupportConversations)
+	support.Get("/conversations/:id", canManageUsers, getSupportConversation)
+	support.Get("/conversations/:id/messages", canManageUsers, getSupportMessages)
+	support.Post("/conversations/:id/messages", canManageUsers, sendSupportMessage)
+	support.Post("/conversations/:id/read", canManageUsers, readSupportMessages)
+
+	stats := api.Group("/statistics", adminWrites(models.PermissionContent))
 	stats.Get("/", getStatistics)
 	stats.Post("/", createStatistics)
 	stats.Put("/", updateStatistics)
 
 	// Product routes
-	products := api.Group("/products")
+	products := api.Group("/products", adminWrites(models.PermissionCatalog))
 	products.Get("/search", searchProducts)
+	products.Get("/suggest", suggestProducts)
 	products.Post("/", createProduct)
 	products.Get("/", getAllProducts)
 	products.Get("/:id", getProduct)
 	products.Put("/:id", updateProduct)
 	products.Delete("/:id", deleteProduct)
 
-	bottomCategories := api.Group("/bottomCategories")
+	bottomCategories := api.Group("/bottomCategories", adminWrites(models.PermissionCatalog))
 	// bottomCategory.Get("/search", searchProducts)
 	bottomCategories.Post("/", createBottomCategory)
 	bottomCategories.Get("/", getAllBottomCategories)
@@ -317,7 +346,7 @@ func SetupRoutes(app *fiber.App) {
 	bottomCategories.Delete("/:id", deleteBottomCategory)
 
 	// Category routes
-	categories := api.Group("/categories")
+	categories := api.Group("/categories", adminWrites(models.PermissionCatalog))
 	categories.Post("/", createCategory)
 	categories.Get("/", getAllCategories)
 	categories.Get("/:id", getCategory)
@@ -325,15 +354,22 @@ func SetupRoutes(app *fiber.App) {
 	categories.Delete("/:id", deleteCategory)
 
 	// Brand routes
-	brands := api.Group("/brands")
+	brands := api.Group("/brands", adminWrites(models.PermissionCatalog))
 	brands.Post("/", createBrand)
 	brands.Get("/", getAllBrands)
 	brands.Get("/:id", getBrand)
 	brands.Put("/:id", updateBrand)
 	brands.Delete("/:id", deleteBrand)
 
+	// Search synonyms are only read by search itself, so listing them is admin-only too
+	synonyms := api.Group("/search-synonyms", requirePermission(models.PermissionCatalog))
+	synonyms.Get("/", getSearchSynonyms)
+	synonyms.Post("/", createSearchSynonym)
+	synonyms.Put("/:id", updateSearchSynonym)
+	synonyms.Delete("/:id", deleteSearchSynonym)
+
 	// Banner routes
-	banners := api.Group("/banners")
+	banners := api.Group("/banners", adminWrites(models.PermissionContent))
 	banners.Post("/", createBanner)
 	banners.Get("/", getAllBanners)
 	banners.Get("/:id", getBanner)
@@ -341,7 +377,7 @@ func SetupRoutes(app *fiber.App) {
 	banners.Delete("/:id", deleteBanner)
 
 	// News routes
-	news := api.Group("/news")
+	news := api.Group("/news", adminWrites(models.PermissionContent))
 	news.Post("/", createNews)
 	news.Get("/", getAllNews)
 	news.Get("/:id", getNewsItem)
@@ -349,7 +385,7 @@ func SetupRoutes(app *fiber.App) {
 	news.Delete("/:id", deleteNews)
 
 	// Achievement routes
-	achievements := api.Group("/achievements")
+	achievements := api.Group("/achievements", adminWrites(models.PermissionContent))
 	achievements.Post("/", createAchievement)
 	achievements.Get("/", getAllAchievements)
 	achievements.Get("/:id", getAchievement)
@@ -357,21 +393,22 @@ func SetupRoutes(app *fiber.App) {
 	achievements.Delete("/:id", deleteAchievement)
 
 	// Rassika routes
+	// Subscribing is public, but the subscriber list holds e-mail addresses and stays admin-only
 	rassikas := api.Group("/rassikas")
 	rassikas.Post("/", createRassika)
-	rassikas.Get("/", getAllRassikas)
-	rassikas.Get("/:id", getRassika)
-	rassikas.Put("/:id", updateRassika)
-	rassikas.Delete("/:id", deleteRassika)
+	rassikas.Get("/", canManageContent, getAllRassikas)
+	rassikas.Get("/:id", canManageContent, getRassika)
+	rassikas.Put("/:id", canManageContent, updateRassika)
+	rassikas.Delete("/:id", canManageContent, deleteRassika)
 
-	hrassikas := api.Group("/hrassikas")
+	hrassikas := api.Group("/hrassikas", adminWrites(models.PermissionContent))
 	hrassikas.Post("/", createHRassika)
 	hrassikas.Get("/", getAllHRassika)
 	hrassikas.Get("/:id", getHRassika)
 	hrassikas.Put("/:id", updateHRassika)
 	hrassikas.Delete("/:id", deleteHRassika)
 
-	clients := api.Group("/clients")
+	clients := api.Group("/clients", adminWrites(models.PermissionContent))
 	clients.Post("/", createClient)
 	clients.Get("/", getAllClients)
 	clients.Get("/:id", getClient)
@@ -380,15 +417,24 @@ func SetupRoutes(app *fiber.App) {
 
 	// Individual Order routes
 	individualOrders := api.Group("/individual-orders")
-	individualOrders.Post("/", createIndividualOrder)
+	individualOrders.Post("/", optionalUser, idempotent, createIndividualOrder)
 	// individualOrders.Get("/", getAllIndividualOrders)
 	// individualOrders.Get("/:id", getIndividualOrder)
 	// individualOrders.Put("/:id", updateIndividualOrder)
 	// individualOrders.Delete("/:id", deleteIndividualOrder)
 
+	// INN check and organization lookup for the legal order form
+	api.Get("/inn/:inn", newRateLimiter(envInt("INN_LOOKUP_RATE_LIMIT", 30), time.Minute).handler, lookupINN)
+
+	// Stock reservation routes; the reservation ID is the only credential needed to use or release it
+	reservations := api.Group("/reservations")
+	reservations.Post("/", reservationLimiter.handler, optionalUser, createReservation)
+	reservations.Get("/:token", getReservation)
+	reservations.Delete("/:token", deleteReservation)
+
 	// Legal Order routes
 	legalOrders := api.Group("/legal-orders")
-	legalOrders.Post("/", createLegalOrder)
+	legalOrders.Post("/", optionalUser, idempotent, createLegalOrder)
 	// legalOrders.Get("/", getAllLegalOrders)
 	// legalOrders.Get("/:id", getLegalOrder)
 	// legalOrders.Put("/:id", updateLegalOrder)
@@ -397,11 +443,14 @@ func SetupRoutes(app *fiber.App) {
 	// Order routes
 	orders := api.Group("/orders")
 	// orders.Post("/", createOrder)
-	orders.Get("/", getAllOrders)
-	orders.Get("/:id", getOrder)
-	orders.Put("/:id", updateOrder)
+	orders.Get("/", canManageOrders, getAllOrders)
+	orders.Get("/track", trackOrderLimiter, trackOrder)
+	orders.Get("/export", canManageOrders, exportOrders)
+	orders.Get("/:id", canManageOrders, getOrder)
+	orders.Get("/:id/invoice", canManageOrders, getOrderInvoice)
+	orders.Put("/:id", canManageOrders, updateOrder)
 	// orders.Put("/:id", updateOrder)
-	orders.Delete("/:id", deleteOrder)
+	orders.Delete("/:id", canManageOrders, deleteOrder)
 }
 
 // Image upload handler
@@ -622,56 +671,200 @@ func deleteClient(c *fiber.Ctx) error {
 	})
 }
 
-func createAdmin(c *fiber.Ctx) error {
-	var existingAdmin models.Admin
-	if err := db.DB.First(&existingAdmin).Error; err == nil {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "Admin already exists. Use PUT to update",
-		})
-	} else if err != gorm.ErrRecordNotFound {
+// requireFirstAdminOrPermission lets the very first admin be created without authentication
+func requireFirstAdminOrPermission(c *fiber.Ctx) error {
+	var count int64
+	if err := db.DB.Model(&models.Admin{}).Count(&count).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Database error checking admin existence",
 		})
 	}
+	if count == 0 {
+		return c.Next()
+	}
+	return requirePermission(models.PermissionAdmins)(c)
+}
 
-	admin := new(models.Admin)
-	if err := c.BodyParser(admin); err != nil {
+// adminLoginHandler - POST /api/admin/login
+func adminLoginHandler(c *fiber.Ctx) error {
+	var req AdminLoginRequest
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Cannot parse JSON",
 		})
 	}
+	if err := validate.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Login and password are required",
+		})
+	}
 
-	if result := db.DB.Create(admin); result.Error != nil {
+	var admin models.Admin
+	if err := db.DB.Where("login = ?", req.Login).First(&admin).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid login or password",
+		})
+	}
+
+	ok, needsRehash := models.CheckPassword(admin.Password, req.Password)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid login or password",
+		})
+	}
+	if needsRehash {
+		if hash, err := models.HashPassword(req.Password); err == nil {
+			db.DB.Model(&admin).Update("password", hash)
+		}
+	}
+
+	tokens, err := issueTokens(tokenKindAdmin, admin.ID)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create admin",
+			"error": "Failed to issue tokens",
 		})
 	}
 
-	return c.JSON(admin)
+	return c.JSON(AdminLoginResponse{
+		Message:     "Login successful",
+		Admin:       admin,
+		Permissions: models.RolePermissions[admin.Role],
+		TokenPair:   *tokens,
+	})
 }
 
-func updateAdmin(c *fiber.Ctx) error {
-	var existingAdmin models.Admin
-	if err := db.DB.First(&existingAdmin).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error": "No admin exists. Use POST to create",
-			})
+// adminUpdates builds the column updates for an admin from the request, hashing the password
+func adminUpdates(req *AdminRequest, excludeID uint) (*models.Admin, int, string) {
+	if req.Login != "" {
+		var count int64
+		if err := db.DB.Model(&models.Admin{}).Where("login = ? AND id != ?", req.Login, excludeID).Count(&count).Error; err != nil {
+			return nil, fiber.StatusInternalServerError, "Failed to check login"
+		}
+		if count > 0 {
+			return nil, fiber.StatusConflict, "Login already in use"
+		}
+	}
+
+	updates := &models.Admin{Name: req.Name, Login: req.Login}
+	if req.Password != "" {
+		hash, err := models.HashPassword(req.Password)
+		if err != nil {
+			return nil, fiber.StatusInternalServerError, "Failed to hash password"
 		}
+		updates.Password = hash
+	}
+	return updates, 0, ""
+}
+
+// ownerCount returns how many admins hold the owner role
+func ownerCount() int64 {
+	var count int64
+	db.DB.Model(&models.Admin{}).Where("role = ?", models.RoleOwner).Count(&count)
+	return count
+}
+
+var errAdminExists = errors.New("an admin already exists")
+
+// createAdmin - POST /api/admin
+// The first admin ever created always becomes the owner.
+func createAdmin(c *fiber.Ctx) error {
+	req := new(AdminRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if req.Name == "" || req.Login == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name, login and password are required",
+		})
+	}
+
+	role := req.Role
+	if currentAdmin(c) == nil {
+		role = models.RoleOwner
+	} else if !models.IsValidRole(role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+
+	admin, status, message := adminUpdates(req, 0)
+	if admin == nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+	admin.Role = role
+
+	// Without a signed-in admin this is the first admin. Another request may have created
+	// one since the middleware looked, so the check is repeated in the insert's transaction.
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if currentAdmin(c) == nil {
+			var count int64
+			if err := tx.Model(&models.Admin{}).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errAdminExists
+			}
+		}
+		return tx.Create(admin).Error
+	})
+	if errors.Is(err, errAdminExists) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "An admin already exists; sign in to create more",
+		})
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Database error checking admin existence",
+			"error": "Failed to create admin",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(admin)
+}
+
+// getAllAdmins - GET /api/admin
+func getAllAdmins(c *fiber.Ctx) error {
+	var admins []models.Admin
+	if err := db.DB.Order("id").Find(&admins).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch admins",
 		})
 	}
 
-	admin := new(models.Admin)
-	if err := c.BodyParser(admin); err != nil {
+	return c.JSON(admins)
+}
+
+// getAdmin - GET /api/admin/me
+func getAdmin(c *fiber.Ctx) error {
+	admin := currentAdmin(c)
+	return c.JSON(fiber.Map{
+		"admin":       admin,
+		"permissions": models.RolePermissions[admin.Role],
+	})
+}
+
+// updateAdmin - PUT /api/admin
+// Updates the signed-in admin's own profile; the role can only be changed by another admin.
+func updateAdmin(c *fiber.Ctx) error {
+	req := new(AdminRequest)
+	if err := c.BodyParser(req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Cannot parse JSON",
 		})
 	}
 
-	admin.ID = 1
-	if result := db.DB.Model(&models.Admin{}).Where("id = ?", 1).Updates(admin); result.Error != nil {
+	admin := currentAdmin(c)
+	updates, status, message := adminUpdates(req, admin.ID)
+	if updates == nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	if result := db.DB.Model(admin).Updates(updates); result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update admin",
 		})
@@ -680,22 +873,98 @@ func updateAdmin(c *fiber.Ctx) error {
 	return c.JSON(admin)
 }
 
-func getAdmin(c *fiber.Ctx) error {
+// updateAdminByID - PUT /api/admin/:id
+func updateAdminByID(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid admin ID",
+		})
+	}
+
 	var admin models.Admin
-	if err := db.DB.First(&admin).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "No admin exists",
+	if err := db.DB.Where("id = ?", id).First(&admin).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Admin not found",
+		})
+	}
+
+	req := new(AdminRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+
+	if req.Role != "" {
+		if !models.IsValidRole(req.Role) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid role",
+			})
+		}
+		if admin.Role == models.RoleOwner && req.Role != models.RoleOwner && ownerCount() <= 1 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Cannot demote the last owner",
 			})
 		}
+	}
+
+	updates, status, message := adminUpdates(req, admin.ID)
+	if updates == nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+	updates.Role = req.Role
+
+	if result := db.DB.Model(&admin).Updates(updates); result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch admin",
+			"error": "Failed to update admin",
 		})
 	}
 
 	return c.JSON(admin)
 }
 
+// deleteAdmin - DELETE /api/admin/:id
+func deleteAdmin(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid admin ID",
+		})
+	}
+
+	var admin models.Admin
+	if err := db.DB.Where("id = ?", id).First(&admin).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Admin not found",
+		})
+	}
+
+	if admin.Role == models.RoleOwner && ownerCount() <= 1 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Cannot delete the last owner",
+		})
+	}
+
+	if err := db.DB.Delete(&admin).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete admin",
+		})
+	}
+
+	// End the deleted admin's sessions
+	db.DB.Model(&models.RefreshToken{}).
+		Where("kind = ? AND subject_id = ? AND revoked_at IS NULL", tokenKindAdmin, admin.ID).
+		Update("revoked_at", time.Now())
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Admin deleted successfully",
+	})
+}
+
 func loginHandler(c *fiber.Ctx) error {
 	// Parse request body
 	var req LoginRequest
@@ -708,7 +977,7 @@ func loginHandler(c *fiber.Ctx) error {
 	// Validate required fields
 	if req.Phone == "" || req.Password == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			" 하기error": "Phone and password are required",
+			"error": "Phone and password are required",
 		})
 	}
 
@@ -720,17 +989,32 @@ func loginHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Compare plain text password
-	if user.Password != req.Password {
+	ok, needsRehash := models.CheckPassword(user.Password, req.Password)
+	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid phone number or password",
 		})
 	}
 
+	// Upgrade passwords still stored in plain text (or with an outdated cost)
+	if needsRehash {
+		if hash, err := models.HashPassword(req.Password); err == nil {
+			db.DB.Model(&user).Update("password", hash)
+		}
+	}
+
+	tokens, err := issueTokens(tokenKindUser, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue tokens",
+		})
+	}
+
 	// Successful login
 	response := LoginResponse{
-		Message: "Login successful",
-		User:    user, // Include full user struct (password excluded by json:"-")
+		Message:   "Login successful",
+		User:      user, // Include full user struct (password excluded by json:"-")
+		TokenPair: *tokens,
 	}
 
 	return c.JSON(response)
@@ -851,13 +1135,28 @@ func updateStatistics(c *fiber.Ctx) error {
 
 // User handlers
 func createUser(c *fiber.Ctx) error {
-	user := new(models.User)
-	if err := c.BodyParser(user); err != nil {
+	req := new(UserRequest)
+	if err := c.BodyParser(req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Failed to parse request body",
 		})
 	}
 
+	user := &models.User{
+		Name:      req.Name,
+		Phone:     req.Phone,
+		RassikaID: req.RassikaID,
+	}
+	if req.Password != "" {
+		hash, err := models.HashPassword(req.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+		user.Password = hash
+	}
+
 	// Validate phone format
 	phoneRegex := regexp.MustCompile(`^\+\d{12}$`) // Adjusted for your 12-digit example
 	if !phoneRegex.MatchString(user.Phone) {
@@ -897,14 +1196,8 @@ func createUser(c *fiber.Ctx) error {
 
 func getAllUsers(c *fiber.Ctx) error {
 	var users []models.User
-	// Preload Orders, OrderItems, and Product (with Category and Brand)
-	if err := db.DB.
-		Preload("Orders.OrderItems.Product.Category").
-		Preload("Orders.OrderItems.Product.Brand").
-		Preload("Orders.OrderItems.Product").
-		Preload("Orders.OrderItems").
-		Preload("Orders").
-		Find(&users).Error; err != nil {
+	// Orders are listed per user via /users/:id/orders
+	if err := db.DB.Find(&users).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get users",
 		})
@@ -917,14 +1210,8 @@ func getUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var user models.User
 
-	// Preload Orders, OrderItems, and Product (with Category and Brand)
-	if err := db.DB.
-		Preload("Orders.OrderItems.Product.Category").
-		Preload("Orders.OrderItems.Product.Brand").
-		Preload("Orders.OrderItems.Product").
-		Preload("Orders.OrderItems").
-		Preload("Orders").
-		First(&user, id).Error; err != nil {
+	// Orders are listed per user via /users/:id/orders
+	if err := db.DB.First(&user, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "User not found",
 		})
@@ -935,14 +1222,29 @@ func getUser(c *fiber.Ctx) error {
 
 func updateUser(c *fiber.Ctx) error {
 	id := c.Params("id")
-	user := new(models.User)
+	req := new(UserRequest)
 
-	if err := c.BodyParser(user); err != nil {
+	if err := c.BodyParser(req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Failed to parse request body",
 		})
 	}
 
+	user := &models.User{
+		Name:      req.Name,
+		Phone:     req.Phone,
+		RassikaID: req.RassikaID,
+	}
+	if req.Password != "" {
+		hash, err := models.HashPassword(req.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+		user.Password = hash
+	}
+
 	var existingUser models.User
 	if err := db.DB.First(&existingUser, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -1032,98 +1334,6 @@ func createProduct(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(product)
 }
 
-func searchProducts(c *fiber.Ctx) error {
-	query := c.Query("q")
-	if query == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Query parameter 'q' is required",
-		})
-	}
-
-	var products []models.Product
-
-	// Step 1: Search by Product Name
-	if err := db.DB.Preload("Category").Preload("Brand").Preload("BottomCategory").
-		Where("name LIKE ?", "%"+query+"%").Find(&products).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to search products",
-		})
-	}
-
-	// If products are found by name, return them
-	if len(products) > 0 {
-		return c.JSON(SearchResponse{Products: products})
-	}
-
-	// Step 2: Search by Category Name
-	var categoryIDs []uint
-	if err := db.DB.Model(&models.Category{}).
-		Where("name LIKE ?", "%"+query+"%").
-		Pluck("id", &categoryIDs).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to search categories",
-		})
-	}
-
-	if len(categoryIDs) > 0 {
-		if err := db.DB.Preload("Category").Preload("Brand").Preload("BottomCategory").
-			Where("category_id IN ?", categoryIDs).Find(&products).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to get products by category",
-			})
-		}
-		// If products are found by category, return them
-		if len(products) > 0 {
-			return c.JSON(SearchResponse{Products: products})
-		}
-	}
-
-	// Step 3: Search by Bottom Category Name
-	var bottomCategoryIDs []uint
-	if err := db.DB.Model(&models.BottomCategory{}). // Assuming BottomCategory is your model name
-								Where("name LIKE ?", "%"+query+"%").
-								Pluck("id", &bottomCategoryIDs).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to search bottom categories",
-		})
-	}
-
-	if len(bottomCategoryIDs) > 0 {
-		if err := db.DB.Preload("Category").Preload("Brand").Preload("BottomCategory").
-			Where("bottom_category_id IN ?", bottomCategoryIDs).Find(&products).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to get products by bottom category",
-			})
-		}
-		// If products are found by bottom category, return them
-		if len(products) > 0 {
-			return c.JSON(SearchResponse{Products: products})
-		}
-	}
-
-	// Step 4: Search by Brand Name
-	var brandIDs []uint
-	if err := db.DB.Model(&models.Brand{}).
-		Where("name LIKE ?", "%"+query+"%").
-		Pluck("id", &brandIDs).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to search brands",
-		})
-	}
-
-	if len(brandIDs) > 0 {
-		if err := db.DB.Preload("Category").Preload("Brand").Preload("BottomCategory").
-			Where("brand_id IN ?", brandIDs).Find(&products).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to get products by brand",
-			})
-		}
-	}
-
-	// Return the products (could be empty if no matches found)
-	return c.JSON(SearchResponse{Products: products})
-}
-
 // GetAllProducts
 func getAllProducts(c *fiber.Ctx) error {
 	var total int64
@@ -2855,19 +3065,15 @@ func deleteHRassika(c *fiber.Ctx) error {
 }
 
 func createIndividualOrder(c *fiber.Ctx) error {
+	// Prices are computed on the server; a client-sent "price" is ignored
 	type IndividualOrderRequest struct {
-		Price      float64 `json:"price" validate:"required,gte=0"`
-		Bonus      float64 `json:"bonus" validate:"gte=0"`
-		UserID     uint    `json:"user_id"`
-		Status     string  `json:"status" validate:"required"`
-		Service    string  `json:"service_mode" validate:"required"`
-		Phone      string  `json:"phone" validate:"required"`
-		Name       string  `json:"name" validate:"required"`
-		Comment    string  `json:"comment"`
-		OrderItems []struct {
-			ProductID uint `json:"product_id" validate:"required"`
-			Quantity  int  `json:"quantity" validate:"required,gte=1"`
-		} `json:"order_items" validate:"required,dive"`
+		Bonus         float64            `json:"bonus" validate:"gte=0"` // Bonus points to redeem
+		Service       string             `json:"service_mode" validate:"required"`
+		Phone         string             `json:"phone" validate:"required"`
+		Name          string             `json:"name" validate:"required"`
+		Comment       string             `json:"comment"`
+		ReservationID string             `json:"reservation_id"` // Stock held via POST /reservations
+		OrderItems    []OrderItemRequest `json:"order_items" validate:"required,dive"`
 	}
 
 	var requestData IndividualOrderRequest
@@ -2884,18 +3090,15 @@ func createIndividualOrder(c *fiber.Ctx) error {
 		})
 	}
 
-	// var user models.User
-	// if err := db.DB.First(&user, requestData.UserID).Error; err != nil {
-	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-	// 		"error": "User not found",
-	// 	})
-	// }
+	// The order belongs to the authenticated customer; guests order without an account
+	var userID uint
+	if user := currentUser(c); user != nil {
+		userID = user.ID
+	}
 
 	order := models.Order{
-		Price:     requestData.Price,
-		Bonus:     requestData.Bonus,
-		UserID:    requestData.UserID,
-		Status:    requestData.Status,
+		UserID:    userID,
+		Status:    models.OrderStatusNew,
 		Service:   requestData.Service,
 		OrderType: "individual",
 		Phone:     requestData.Phone,
@@ -2903,142 +3106,19 @@ func createIndividualOrder(c *fiber.Ctx) error {
 		Comment:   requestData.Comment,
 	}
 
-	tx := db.DB.Begin()
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create individual order: " + err.Error(),
-		})
-	}
-
-	var orderItems []models.OrderItem
-	var calculatedPrice float64
-	for _, item := range requestData.OrderItems {
-		var product models.Product
-		if err := tx.First(&product, item.ProductID).Error; err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Product %d not found", item.ProductID),
-			})
-		}
-
-		if uint(item.Quantity) > product.Quantity {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Insufficient quantity for product %d", item.ProductID),
-			})
-		}
-
-		orderItems = append(orderItems, models.OrderItem{
-			OrderID:   order.ID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-		})
-		calculatedPrice += product.Price * float64(item.Quantity)
-	}
-
-	// if calculatedPrice != requestData.Price {
-	// 	tx.Rollback()
-	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-	// 		"error":    "Price doesn't match order items total",
-	// 		"expected": calculatedPrice,
-	// 		"received": requestData.Price,
-	// 	})
-	// }
-
-	if err := tx.Create(&orderItems).Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create order items: " + err.Error(),
-		})
-	}
-
-	for _, item := range orderItems {
-		if err := tx.Model(&models.Product{}).
-			Where("id = ?", item.ProductID).
-			Update("quantity", gorm.Expr("quantity - ?", item.Quantity)).Error; err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update product quantities",
-			})
-		}
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to commit transaction",
-		})
-	}
-
-	// Verify the association
-	var checkUser models.User
-	if err := db.DB.Preload("Orders").First(&checkUser, requestData.UserID).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Order created but failed to verify user association",
-		})
-	}
-	fmt.Printf("User orders after creation: %+v\n", checkUser.Orders) // Debug log
-
-	var fullOrder models.Order
-	if err := db.DB.Preload("OrderItems.Product").First(&fullOrder, order.ID).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Order created but failed to load full details",
-		})
-	}
-
-	orderResponse := OrderResponse{
-		ID:        fullOrder.ID,
-		Price:     fullOrder.Price,
-		Bonus:     fullOrder.Bonus,
-		UserID:    fullOrder.UserID,
-		Status:    fullOrder.Status,
-		Service:   fullOrder.Service,
-		OrderType: fullOrder.OrderType,
-		Comment:   fullOrder.Comment,
-		Phone:     fullOrder.Phone,
-		Name:      fullOrder.Name,
-		CreatedAt: fullOrder.CreatedAt,
-		UpdatedAt: fullOrder.UpdatedAt,
-	}
-
-	for _, item := range fullOrder.OrderItems {
-		orderResponse.OrderItems = append(orderResponse.OrderItems, OrderItemResponse{
-			OrderQuantity: item.Quantity,
-			ID:            item.Product.ID,
-			Name:          item.Product.Name,
-			Rating:        item.Product.Rating,
-			Quantity:      item.Product.Quantity,
-			Description:   item.Product.Description,
-			Images:        item.Product.Images,
-			Price:         item.Product.Price,
-			Info:          item.Product.Info,
-			Feature:       item.Product.Feature,
-			Guarantee:     item.Product.Guarantee,
-			Discount:      item.Product.Discount,
-			CreatedAt:     item.Product.CreatedAt,
-			UpdatedAt:     item.Product.UpdatedAt,
-			CategoryID:    item.Product.CategoryID,
-			BrandID:       item.Product.BrandID,
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(orderResponse)
+	return placeOrder(c, &order, requestData.OrderItems, requestData.Bonus, requestData.ReservationID)
 }
 
 func createLegalOrder(c *fiber.Ctx) error {
+	// Prices are computed on the server; a client-sent "price" is ignored
 	type LegalOrderRequest struct {
-		Price        float64 `json:"price" validate:"required,gte=0"`
-		Bonus        float64 `json:"bonus" validate:"gte=0"`
-		UserID       uint    `json:"user_id"`
-		Status       string  `json:"status" validate:"required"`
-		Service      string  `json:"service_mode" validate:"required"`
-		Organization string  `json:"organization" validate:"required"`
-		INN          string  `json:"inn" validate:"required"`
-		Comment      string  `json:"comment"`
-		OrderItems   []struct {
-			ProductID uint `json:"product_id" validate:"required"`
-			Quantity  int  `json:"quantity" validate:"required,gte=1"`
-		} `json:"order_items" validate:"required,dive"`
+		Bonus         float64            `json:"bonus" validate:"gte=0"` // Bonus points to redeem
+		Service       string             `json:"service_mode" validate:"required"`
+		Organization  string             `json:"organization"` // Filled from the INN registry when empty
+		INN           string             `json:"inn"`
+		Comment       string             `json:"comment"`
+		ReservationID string             `json:"reservation_id"` // Stock held via POST /reservations
+		OrderItems    []OrderItemRequest `json:"order_items" validate:"required,dive"`
 	}
 
 	var requestData LegalOrderRequest
@@ -3055,18 +3135,22 @@ func createLegalOrder(c *fiber.Ctx) error {
 		})
 	}
 
-	// var user models.User
-	// if err := db.DB.First(&user, requestData.UserID).Error; err != nil {
-	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-	// 		"error": "User not found",
-	// 	})
-	// }
+	if fields := validateLegalOrderFields(c.UserContext(), &requestData.INN, &requestData.Organization); len(fields) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fields,
+		})
+	}
+
+	// The order belongs to the authenticated customer; guests order without an account
+	var userID uint
+	if user := currentUser(c); user != nil {
+		userID = user.ID
+	}
 
 	order := models.Order{
-		Price:        requestData.Price,
-		Bonus:        requestData.Bonus,
-		UserID:       requestData.UserID,
-		Status:       requestData.Status,
+		UserID:       userID,
+		Status:       models.OrderStatusNew,
 		Service:      requestData.Service,
 		OrderType:    "legal",
 		Organization: requestData.Organization,
@@ -3074,140 +3158,21 @@ func createLegalOrder(c *fiber.Ctx) error {
 		Comment:      requestData.Comment,
 	}
 
-	tx := db.DB.Begin()
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create legal order: " + err.Error(),
-		})
-	}
-
-	var orderItems []models.OrderItem
-	var calculatedPrice float64
-	for _, item := range requestData.OrderItems {
-		var product models.Product
-		if err := tx.First(&product, item.ProductID).Error; err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Product %d not found", item.ProductID),
-			})
-		}
-
-		if uint(item.Quantity) > product.Quantity {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Insufficient quantity for product %d", item.ProductID),
-			})
-		}
-
-		orderItems = append(orderItems, models.OrderItem{
-			OrderID:   order.ID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-		})
-		calculatedPrice += product.Price * float64(item.Quantity)
-	}
-
-	// if calculatedPrice != requestData.Price {
-	// 	tx.Rollback()
-	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-	// 		"error":    "Price doesn't match order items total",
-	// 		"expected": calculatedPrice,
-	// 		"received": requestData.Price,
-	// 	})
-	// }
-
-	if err := tx.Create(&orderItems).Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create order items: " + err.Error(),
-		})
-	}
-
-	for _, item := range orderItems {
-		if err := tx.Model(&models.Product{}).
-			Where("id = ?", item.ProductID).
-			Update("quantity", gorm.Expr("quantity - ?", item.Quantity)).Error; err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update product quantities",
-			})
-		}
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to commit transaction",
-		})
-	}
-
-	// Verify the association (optional debug step)
-	var checkUser models.User
-	if err := db.DB.Preload("Orders").First(&checkUser, requestData.UserID).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Order created but failed to verify user association",
-		})
-	}
-	fmt.Printf("User orders after creation: %+v\n", checkUser.Orders) // Debug log
-
-	var fullOrder models.Order
-	if err := db.DB.Preload("OrderItems.Product").First(&fullOrder, order.ID).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Order created but failed to load full details",
-		})
-	}
-
-	orderResponse := OrderResponse{
-		ID:           fullOrder.ID,
-		Price:        fullOrder.Price,
-		Bonus:        fullOrder.Bonus,
-		UserID:       fullOrder.UserID,
-		Status:       fullOrder.Status,
-		Service:      fullOrder.Service,
-		OrderType:    fullOrder.OrderType,
-		Organization: fullOrder.Organization,
-		INN:          fullOrder.INN,
-		Comment:      fullOrder.Comment,
-		CreatedAt:    fullOrder.CreatedAt,
-		UpdatedAt:    fullOrder.UpdatedAt,
-	}
-
-	for _, item := range fullOrder.OrderItems {
-		orderResponse.OrderItems = append(orderResponse.OrderItems, OrderItemResponse{
-			OrderQuantity: item.Quantity,
-			ID:            item.Product.ID,
-			Name:          item.Product.Name,
-			Rating:        item.Product.Rating,
-			Quantity:      item.Product.Quantity,
-			Description:   item.Product.Description,
-			Images:        item.Product.Images,
-			Price:         item.Product.Price,
-			Info:          item.Product.Info,
-			Feature:       item.Product.Feature,
-			Guarantee:     item.Product.Guarantee,
-			Discount:      item.Product.Discount,
-			CreatedAt:     item.Product.CreatedAt,
-			UpdatedAt:     item.Product.UpdatedAt,
-			CategoryID:    item.Product.CategoryID,
-			BrandID:       item.Product.BrandID,
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(orderResponse)
+	return placeOrder(c, &order, requestData.OrderItems, requestData.Bonus, requestData.ReservationID)
 }
 
 func updateOrder(c *fiber.Ctx) error {
 	// Request struct that combines both individual and legal order fields
 	type UpdateOrderRequest struct {
-		ID           uint    `json:"id" validate:"required"`
-		Price        float64 `json:"price" validate:"gte=0"`
-		Bonus        float64 `json:"bonus" validate:"gte=0"`
-		Status       string  `json:"status"`
-		Phone        string  `json:"phone"`        // For individual orders
-		Name         string  `json:"name"`         // For individual orders
-		Organization string  `json:"organization"` // For legal orders
-		INN          string  `json:"inn"`          // For legal orders
-		Comment      string  `json:"comment"`      // For legal orders
+		ID           uint              `json:"id" validate:"required"`
+		Status       string            `json:"status"`
+		Phone        string            `json:"phone"`                 // For individual orders
+		Name         string            `json:"name"`                  // For individual orders
+		Organization string            `json:"organization"`          // For legal orders
+		INN          string            `json:"inn"`                   // For legal orders
+		Comment      string            `json:"comment"`               // For legal orders
+		Note         string            `json:"note"`                  // Recorded in the order history
+		Items        []OrderItemChange `json:"items" validate:"dive"` // Item edits, allowed until the order ships
 	}
 
 	var requestData UpdateOrderRequest
@@ -3233,6 +3198,7 @@ func updateOrder(c *fiber.Ctx) error {
 			"error": "Order not found",
 		})
 	}
+	previousStatus := order.Status
 
 	// Update fields based on order type
 	if order.OrderType == "individual" {
@@ -3244,29 +3210,70 @@ func updateOrder(c *fiber.Ctx) error {
 		}
 	} else if order.OrderType == "legal" {
 		if requestData.Organization != "" {
-			order.Organization = requestData.Organization
+			if err := validateOrganization(requestData.Organization); err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":  "Validation failed",
+					"fields": fiber.Map{"organization": err.Error()},
+				})
+			}
+			order.Organization = strings.TrimSpace(requestData.Organization)
 		}
 		if requestData.INN != "" {
-			order.INN = requestData.INN
+			inn := normalizeINN(requestData.INN)
+			if _, err := validateINN(inn); err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":  "Validation failed",
+					"fields": fiber.Map{"inn": err.Error()},
+				})
+			}
+			order.INN = inn
 		}
 		if requestData.Comment != "" {
 			order.Comment = requestData.Comment
 		}
 	}
 
-	// Update common fields if provided
-	if requestData.Price > 0 {
-		order.Price = requestData.Price
-	}
-	if requestData.Bonus > 0 {
-		order.Bonus = requestData.Bonus
+	// Item edits come before the status change so "add an item and ship" edits a packed order
+	if len(requestData.Items) > 0 {
+		if err := editOrderItems(tx, &order, requestData.Items, requestData.Note, actorFromContext(c)); err != nil {
+			tx.Rollback()
+			if oe, ok := err.(*orderError); ok {
+				return c.Status(oe.Status).JSON(fiber.Map{
+					"error": oe.Message,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update order items",
+			})
+		}
 	}
-	if requestData.Status != "" {
-		order.Status = requestData.Status
+
+	// Status changes must follow the order lifecycle
+	if requestData.Status != "" && requestData.Status != order.Status {
+		currentStatus := order.Status
+		if err := changeOrderStatus(tx, &order, requestData.Status, requestData.Note, actorFromContext(c)); err != nil {
+			tx.Rollback()
+			switch err {
+			case errInvalidStatusTransition:
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error":   fmt.Sprintf("Cannot change order status from %q to %q", currentStatus, requestData.Status),
+					"allowed": models.AllowedOrderStatuses(currentStatus),
+				})
+			case errOrderStatusChanged:
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Order status was changed by someone else, reload and try again",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update order status",
+			})
+		}
 	}
 
-	// Save the updated order
-	if err := tx.Save(&order).Error; err != nil {
+	// Save the updated order; items were already written above
+	if err := tx.Omit(clause.Associations).Save(&order).Error; err != nil {
 		tx.Rollback()
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update order: " + err.Error(),
@@ -3279,182 +3286,170 @@ func updateOrder(c *fiber.Ctx) error {
 		})
 	}
 
+	if order.Status != previousStatus {
+		publishOrderStatusChanged(OrderStatusChangedEvent{
+			OrderID: order.ID,
+			Number:  order.Number,
+			From:    previousStatus,
+			To:      order.Status,
+			Note:    requestData.Note,
+		})
+	}
+	if len(requestData.Items) > 0 {
+		productIDs := make([]uint, 0, len(requestData.Items))
+		for _, change := range requestData.Items {
+			productIDs = append(productIDs, change.ProductID)
+		}
+		publishLowStock(productIDs)
+	}
+
 	// Load full order details for response
 	var fullOrder models.Order
-	if err := db.DB.Preload("OrderItems.Product").First(&fullOrder, order.ID).Error; err != nil {
+	if err := db.DB.Preload("OrderItems.Product").Preload("StatusHistory").First(&fullOrder, order.ID).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Order updated but failed to load full details",
 		})
 	}
 
-	// Prepare response
-	orderResponse := OrderResponse{
-		ID:           fullOrder.ID,
-		Price:        fullOrder.Price,
-		Bonus:        fullOrder.Bonus,
-		UserID:       fullOrder.UserID,
-		Status:       fullOrder.Status,
-		OrderType:    fullOrder.OrderType,
-		Phone:        fullOrder.Phone,
-		Name:         fullOrder.Name,
-		Organization: fullOrder.Organization,
-		INN:          fullOrder.INN,
-		Comment:      fullOrder.Comment,
-		CreatedAt:    fullOrder.CreatedAt,
-		UpdatedAt:    fullOrder.UpdatedAt,
-	}
-
-	for _, item := range fullOrder.OrderItems {
-		orderResponse.OrderItems = append(orderResponse.OrderItems, OrderItemResponse{
-			OrderQuantity: item.Quantity,
-			ID:            item.Product.ID,
-			Name:          item.Product.Name,
-			Rating:        item.Product.Rating,
-			Quantity:      item.Product.Quantity,
-			Description:   item.Product.Description,
-			Images:        item.Product.Images,
-			Price:         item.Product.Price,
-			Info:          item.Product.Info,
-			Feature:       item.Product.Feature,
-			Guarantee:     item.Product.Guarantee,
-			Discount:      item.Product.Discount,
-			CreatedAt:     item.Product.CreatedAt,
-			UpdatedAt:     item.Product.UpdatedAt,
-			CategoryID:    item.Product.CategoryID,
-			BrandID:       item.Product.BrandID,
-		})
-	}
+	orderResponse := newOrderResponse(fullOrder)
 
 	return c.Status(fiber.StatusOK).JSON(orderResponse)
 }
 
+// getAllOrders - GET /orders with filters, sorting and skip/limit paging
 func getAllOrders(c *fiber.Ctx) error {
+	var total int64
 	var orders []models.Order
 
-	// Fetch orders with preloaded OrderItems and Products
-	if err := db.DB.Preload("OrderItems.Product").Find(&orders).Error; err != nil {
+	skip, limit, err := parsePaging(c, 50, 200)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	dbQuery, err := applyOrderFilters(c, db.DB.Model(&models.Order{}))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Count total orders matching the filters
+	if err := dbQuery.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count orders",
+		})
+	}
+
+	dbQuery, err = applyOrderSort(c, dbQuery)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Fetch the page with preloaded OrderItems and Products
+	if err := dbQuery.Preload("OrderItems.Product").Offset(skip).Limit(limit).Find(&orders).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get orders",
 		})
 	}
 
 	// Transform into response format
-	var orderResponses []OrderResponse
+	orderResponses := []OrderResponse{}
 	for _, order := range orders {
-		orderResponse := OrderResponse{
-			ID:           order.ID,
-			Price:        order.Price,
-			Bonus:        order.Bonus,
-			UserID:       order.UserID,
-			Status:       order.Status,
-			Service:      order.Service,
-			OrderType:    order.OrderType,
-			Phone:        order.Phone,
-			Name:         order.Name,
-			Organization: order.Organization,
-			INN:          order.INN,
-			Comment:      order.Comment,
-			CreatedAt:    order.CreatedAt,
-			UpdatedAt:    order.UpdatedAt,
-		}
+		orderResponses = append(orderResponses, newOrderResponse(order))
+	}
 
-		for _, item := range order.OrderItems {
-			orderResponse.OrderItems = append(orderResponse.OrderItems, OrderItemResponse{
-				OrderQuantity: item.Quantity,
-				ID:            item.Product.ID,
-				Name:          item.Product.Name,
-				Rating:        item.Product.Rating,
-				Quantity:      item.Product.Quantity,
-				Description:   item.Product.Description,
-				Images:        item.Product.Images,
-				Price:         item.Product.Price,
-				Info:          item.Product.Info,
-				Feature:       item.Product.Feature,
-				Guarantee:     item.Product.Guarantee,
-				Discount:      item.Product.Discount,
-				CreatedAt:     item.Product.CreatedAt,
-				UpdatedAt:     item.Product.UpdatedAt,
-				CategoryID:    item.Product.CategoryID,
-				BrandID:       item.Product.BrandID,
-			})
-		}
-		orderResponses = append(orderResponses, orderResponse)
+	response := struct {
+		Orders []OrderResponse `json:"orders"`
+		Total  int             `json:"total"`
+		Skip   int             `json:"skip"`
+		Limit  int             `json:"limit"`
+	}{
+		Orders: orderResponses,
+		Total:  int(total),
+		Skip:   skip,
+		Limit:  limit,
 	}
 
-	return c.JSON(orderResponses)
+	return c.JSON(response)
 }
 
 func getOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var order models.Order
 
-	// Fetch order with preloaded OrderItems and Products
-	if err := db.DB.Preload("OrderItems.Product").First(&order, id).Error; err != nil {
+	// Fetch order with preloaded OrderItems, Products and the status history
+	if err := db.DB.Preload("OrderItems.Product").Preload("StatusHistory").First(&order, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Order not found",
 		})
 	}
 
-	// Transform into response format
-	orderResponse := OrderResponse{
-		ID:           order.ID,
-		Price:        order.Price,
-		Bonus:        order.Bonus,
-		UserID:       order.UserID,
-		Status:       order.Status,
-		Service:      order.Service,
-		OrderType:    order.OrderType,
-		Phone:        order.Phone,
-		Name:         order.Name,
-		Organization: order.Organization,
-		INN:          order.INN,
-		Comment:      order.Comment,
-		CreatedAt:    order.CreatedAt,
-		UpdatedAt:    order.UpdatedAt,
-	}
-
-	for _, item := range order.OrderItems {
-		orderResponse.OrderItems = append(orderResponse.OrderItems, OrderItemResponse{
-			OrderQuantity: item.Quantity,
-			ID:            item.Product.ID,
-			Name:          item.Product.Name,
-			Rating:        item.Product.Rating,
-			Quantity:      item.Product.Quantity,
-			Description:   item.Product.Description,
-			Images:        item.Product.Images,
-			Price:         item.Product.Price,
-			Info:          item.Product.Info,
-			Feature:       item.Product.Feature,
-			Guarantee:     item.Product.Guarantee,
-			Discount:      item.Product.Discount,
-			CreatedAt:     item.Product.CreatedAt,
-			UpdatedAt:     item.Product.UpdatedAt,
-			CategoryID:    item.Product.CategoryID,
-			BrandID:       item.Product.BrandID,
-		})
-	}
-
-	return c.JSON(orderResponse)
+	return c.JSON(newOrderResponse(order))
 }
 
 func deleteOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 
+	tx := db.DB.Begin()
+
 	// Check if the order exists first
 	var order models.Order
-	if err := db.DB.First(&order, id).Error; err != nil {
+	if err := tx.First(&order, id).Error; err != nil {
+		tx.Rollback()
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Order not found",
 		})
 	}
 
+	// Goods of a delivered order are with the customer; anything else goes back to stock
+	// and the customer gets back the bonus they redeemed on it
+	if order.Status != models.OrderStatusDelivered {
+		if err := restockOrder(tx, &order); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to restock order items",
+			})
+		}
+		if err := refundOrderBonus(tx, &order, "Order deleted"); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to refund order bonus",
+			})
+		}
+	}
+
+	// Delete associated order items and history
+	if err := tx.Where("order_id = ?", order.ID).Delete(&models.OrderItem{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete order items",
+		})
+	}
+	if err := tx.Where("order_id = ?", order.ID).Delete(&models.OrderStatusHistory{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete order history",
+		})
+	}
+
 	// Delete the order
-	if err := db.DB.Delete(&order).Error; err != nil {
+	if err := tx.Delete(&order).Error; err != nil {
+		tx.Rollback()
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete order",
 		})
 	}
 
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to commit transaction",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Order deleted successfully",
@@ -0,0 +1,454 @@
+package routes
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Search settings
+const (
+	searchMaxTerms      = 10
+	searchSnippetTokens = 12
+	searchSnippetRunes  = 80
+	searchMarkOpen      = "<mark>"
+	searchMarkClose     = "</mark>"
+
+	// SQLite wraps matches in these private use characters; markHits turns them into
+	// <mark> tags once the product text around them is escaped
+	searchHitOpen  = "\uE000"
+	searchHitClose = "\uE001"
+)
+
+// searchRank weighs a match in products_fts columns: name, description, info, feature,
+// category, bottom category, brand
+const searchRank = "bm25(products_fts, 10.0, 1.0, 1.0, 1.0, 4.0, 4.0, 4.0)"
+
+// ProductSearchResult is a matching product with its name and the best matching
+// fragment as HTML: the product text escaped, matches highlighted with <mark> tags
+type ProductSearchResult struct {
+	models.Product
+	NameHighlight string `json:"name_highlight"`
+	Snippet       string `json:"snippet"`
+}
+
+type SearchResponse struct {
+	Products []ProductSearchResult `json:"products"`
+	Total    int64                 `json:"total"`
+	Skip     int                   `json:"skip"`
+	Limit    int                   `json:"limit"`
+}
+
+// searchHit is one ranked match before its product is loaded
+type searchHit struct {
+	ID            uint
+	NameHighlight string
+	Snippet       string
+}
+
+// searchTerms splits a query into words
+func searchTerms(query string) []string {
+	terms := splitWords(query)
+	if len(terms) > searchMaxTerms {
+		terms = terms[:searchMaxTerms]
+	}
+	return terms
+}
+
+// ftsMatch builds an FTS5 query matching products that contain, for each query word, a
+// word starting with it or one of the indexed words it expanded to
+func ftsMatch(expanded [][]string) string {
+	parts := make([]string, len(expanded))
+	for i, forms := range expanded {
+		alternatives := make([]string, len(forms))
+		for j, form := range forms {
+			alternatives[j] = `"` + strings.ReplaceAll(form, `"`, `""`) + `"`
+		}
+		alternatives[0] += "*"
+		parts[i] = "(" + strings.Join(alternatives, " OR ") + ")"
+	}
+	// FTS5 only joins plain phrases implicitly; groups in parentheses need an explicit AND
+	return strings.Join(parts, " AND ")
+}
+
+// searchProductsFTS ranks matches with bm25 over the products_fts index
+func searchProductsFTS(expanded [][]string, skip, limit int) ([]searchHit, int64, error) {
+	match := ftsMatch(expanded)
+
+	var total int64
+	if err := db.DB.Raw("SELECT count(*) FROM products_fts WHERE products_fts MATCH ?", match).
+		Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var hits []searchHit
+	err := db.DB.Raw(`SELECT rowid AS id,
+			highlight(products_fts, 0, ?, ?) AS name_highlight,
+			snippet(products_fts, -1, ?, ?, '…', ?) AS snippet
+		FROM products_fts WHERE products_fts MATCH ?
+		ORDER BY `+searchRank+`, rowid LIMIT ? OFFSET ?`,
+		searchHitOpen, searchHitClose, searchHitOpen, searchHitClose, searchSnippetTokens,
+		match, limit, skip).Scan(&hits).Error
+	for i := range hits {
+		hits[i].NameHighlight = markHits(hits[i].NameHighlight)
+		hits[i].Snippet = markHits(hits[i].Snippet)
+	}
+	return hits, total, err
+}
+
+// markHits escapes text for HTML and turns the match delimiters SQLite put around matches
+// into <mark> tags
+func markHits(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, searchHitOpen, searchMarkOpen)
+	return strings.ReplaceAll(text, searchHitClose, searchMarkClose)
+}
+
+// searchProductsLike is the fallback for SQLite builds without FTS5. Every query word, or
+// one of the words it expanded to, has to appear in one of the searched fields; matches on
+// the name come first, then matches on the category, bottom category or brand, then the rest.
+func searchProductsLike(expanded [][]string, skip, limit int) ([]searchHit, int64, error) {
+	query := db.DB.Table("products p").
+		Joins("LEFT JOIN categories c ON c.id = p.category_id").
+		Joins("LEFT JOIN bottom_categories bc ON bc.id = p.bottom_category_id").
+		Joins("LEFT JOIN brands b ON b.id = p.brand_id")
+	nameMatch, groupMatch := []string{}, []string{}
+	var nameArgs, groupArgs []interface{}
+	var forms []string
+	for _, alternatives := range expanded {
+		var anyMatch, anyName, anyGroup []string
+		var anyArgs []interface{}
+		for _, form := range alternatives {
+			like := "%" + form + "%"
+			anyMatch = append(anyMatch, `p.name LIKE ? OR p.description LIKE ? OR p.info LIKE ? OR p.feature LIKE ?
+				OR c.name LIKE ? OR bc.name LIKE ? OR b.name LIKE ?`)
+			anyArgs = append(anyArgs, like, like, like, like, like, like, like)
+			anyName = append(anyName, "p.name LIKE ?")
+			nameArgs = append(nameArgs, like)
+			anyGroup = append(anyGroup, "c.name LIKE ? OR bc.name LIKE ? OR b.name LIKE ?")
+			groupArgs = append(groupArgs, like, like, like)
+		}
+		query = query.Where("("+strings.Join(anyMatch, " OR ")+")", anyArgs...)
+		nameMatch = append(nameMatch, "("+strings.Join(anyName, " OR ")+")")
+		groupMatch = append(groupMatch, "("+strings.Join(anyGroup, " OR ")+")")
+		forms = append(forms, alternatives...)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []struct {
+		ID                                          uint
+		Name, Description, Info, Feature            string
+		CategoryName, BottomCategoryName, BrandName string
+	}
+	order := "CASE WHEN " + strings.Join(nameMatch, " AND ") + " THEN 0 WHEN " +
+		strings.Join(groupMatch, " AND ") + " THEN 1 ELSE 2 END, p.id"
+	if err := query.Select(`p.id, p.name, p.description, p.info, p.feature,
+			COALESCE(c.name, '') AS category_name, COALESCE(bc.name, '') AS bottom_category_name,
+			COALESCE(b.name, '') AS brand_name`).
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL: order, Vars: append(nameArgs, groupArgs...), WithoutParentheses: true,
+		}}).
+		Offset(skip).Limit(limit).Scan(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+
+	pattern := searchPattern(forms)
+	hits := make([]searchHit, len(rows))
+	for i, row := range rows {
+		hits[i] = searchHit{ID: row.ID, NameHighlight: highlightTerms(row.Name, pattern)}
+		for _, field := range []string{row.Name, row.Description, row.Info, row.Feature,
+			row.CategoryName, row.BottomCategoryName, row.BrandName} {
+			if loc := pattern.FindStringIndex(field); loc != nil {
+				hits[i].Snippet = highlightTerms(snippetAround(field, loc[0]), pattern)
+				break
+			}
+		}
+	}
+	return hits, total, nil
+}
+
+// searchPattern matches any of the words, ignoring case
+func searchPattern(words []string) *regexp.Regexp {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// highlightTerms escapes text for HTML and wraps every match of pattern with <mark> tags
+func highlightTerms(text string, pattern *regexp.Regexp) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:loc[0]]))
+		b.WriteString(searchMarkOpen + html.EscapeString(text[loc[0]:loc[1]]) + searchMarkClose)
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// snippetAround cuts a fragment of text of about searchSnippetRunes runes around the byte
+// offset at, marking cut ends with an ellipsis
+func snippetAround(text string, at int) string {
+	runes := []rune(text)
+	if len(runes) <= searchSnippetRunes {
+		return text
+	}
+	start := len([]rune(text[:at])) - searchSnippetRunes/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + searchSnippetRunes
+	if end > len(runes) {
+		end = len(runes)
+		start = end - searchSnippetRunes
+	}
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// searchProducts - GET /products/search
+func searchProducts(c *fiber.Ctx) error {
+	query := c.Query("q")
+	if query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Query parameter 'q' is required",
+		})
+	}
+
+	skip, limit, err := parsePaging(c, 20, 100)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	response := SearchResponse{Products: []ProductSearchResult{}, Skip: skip, Limit: limit}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return c.JSON(response)
+	}
+
+	expanded := expandSearchTerms(terms)
+	var hits []searchHit
+	if db.FullTextSearch {
+		hits, response.Total, err = searchProductsFTS(expanded, skip, limit)
+	} else {
+		hits, response.Total, err = searchProductsLike(expanded, skip, limit)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search products",
+		})
+	}
+	if len(hits) == 0 {
+		return c.JSON(response)
+	}
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	var products []models.Product
+	if err := db.DB.Preload("Category").Preload("Brand").Preload("BottomCategory").
+		Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search products",
+		})
+	}
+	byID := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	// Keep the ranking order of the hits
+	for _, hit := range hits {
+		product, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		response.Products = append(response.Products, ProductSearchResult{
+			Product:       product,
+			NameHighlight: hit.NameHighlight,
+			Snippet:       hit.Snippet,
+		})
+	}
+
+	return c.JSON(response)
+}
+
+// Suggestion types
+const (
+	SuggestionProduct        = "product"
+	SuggestionCategory       = "category"
+	SuggestionBottomCategory = "bottom_category"
+	SuggestionBrand          = "brand"
+)
+
+// Suggestion is one entry of the search box dropdown
+type Suggestion struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// nameMatchesTerms reports whether name has a word matching each query word, given as
+// its normalized variants
+func nameMatchesTerms(name string, variants [][]string) bool {
+	words := splitWords(normalizeSearchText(name))
+	for _, termVariants := range variants {
+		found := false
+		for _, word := range words {
+			if wordMatchScore(word, termVariants) >= 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// suggestGroups finds categories, bottom categories and brands whose name matches the
+// query words. These tables are small, so names are matched in Go, which unlike SQLite's
+// LIKE ignores case outside ASCII and can apply the same normalization as the query.
+func suggestGroups(variants [][]string, limit int) ([]Suggestion, error) {
+	sources := []struct {
+		kind  string
+		model interface{}
+	}{
+		{SuggestionCategory, &models.Category{}},
+		{SuggestionBottomCategory, &models.BottomCategory{}},
+		{SuggestionBrand, &models.Brand{}},
+	}
+
+	suggestions := []Suggestion{}
+	for _, source := range sources {
+		var rows []struct {
+			ID    uint
+			Name  string
+			Image string
+		}
+		if err := db.DB.Model(source.model).Select("id", "name", "image").Order("name").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if len(suggestions) == limit {
+				return suggestions, nil
+			}
+			if nameMatchesTerms(row.Name, variants) {
+				suggestions = append(suggestions, Suggestion{
+					ID: row.ID, Name: row.Name, Type: source.kind, Thumbnail: row.Image,
+				})
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+// suggestProductIDs finds products with a word of the name starting with each query word
+// or matching one of the words it expanded to, best matches first
+func suggestProductIDs(expanded [][]string, limit int) ([]uint, error) {
+	var ids []uint
+	if db.FullTextSearch {
+		err := db.DB.Raw(`SELECT rowid FROM products_fts WHERE products_fts MATCH ?
+			ORDER BY `+searchRank+`, rowid LIMIT ?`, "name : ("+ftsMatch(expanded)+")", limit).
+			Scan(&ids).Error
+		return ids, err
+	}
+
+	query := db.DB.Model(&models.Product{})
+	for _, forms := range expanded {
+		var anyName []string
+		var args []interface{}
+		for _, form := range forms {
+			anyName = append(anyName, "name LIKE ? OR name LIKE ?")
+			args = append(args, form+"%", "% "+form+"%")
+		}
+		query = query.Where("("+strings.Join(anyName, " OR ")+")", args...)
+	}
+	err := query.Order("length(name)").Order("id").Limit(limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// suggestProducts - GET /products/suggest
+func suggestProducts(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 8)
+	if limit <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit parameter",
+		})
+	}
+	if limit > 20 {
+		limit = 20
+	}
+
+	terms := searchTerms(c.Query("q"))
+	if len(terms) == 0 {
+		return c.JSON(fiber.Map{"suggestions": []Suggestion{}})
+	}
+
+	// Categories, bottom categories and brands take up to half of the list and products
+	// fill the rest
+	suggestions, err := suggestGroups(searchTermVariants(terms), (limit+1)/2)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get suggestions",
+		})
+	}
+
+	ids, err := suggestProductIDs(expandSearchTerms(terms), limit-len(suggestions))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get suggestions",
+		})
+	}
+	if len(ids) > 0 {
+		var products []models.Product
+		if err := db.DB.Select("id", "name", "images").Where("id IN ?", ids).
+			Find(&products).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to get suggestions",
+			})
+		}
+		byID := make(map[uint]models.Product, len(products))
+		for _, product := range products {
+			byID[product.ID] = product
+		}
+		for _, id := range ids {
+			product, ok := byID[id]
+			if !ok {
+				continue
+			}
+			suggestion := Suggestion{ID: product.ID, Name: product.Name, Type: SuggestionProduct}
+			if len(product.Images) > 0 {
+				suggestion.Thumbnail = product.Images[0]
+			}
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	return c.JSON(fiber.Map{"suggestions": suggestions})
+}
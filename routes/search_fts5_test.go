@@ -0,0 +1,17 @@
+//go:build sqlite_fts5
+
+package routes
+
+import (
+	"testing"
+
+	"weldmart/db"
+)
+
+// A build with the tag must have the FTS5 index rather than fall back to LIKE queries
+func TestFullTextSearchAvailable(t *testing.T) {
+	openTestDB(t)
+	if !db.FullTextSearch {
+		t.Fatal("built with sqlite_fts5 but the products_fts index is not available")
+	}
+}
@@ -0,0 +1,285 @@
+package routes
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"weldmart/db"
+	"weldmart/models"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Query expansion settings
+var (
+	// How long the vocabulary of indexed words and the synonyms are cached
+	searchVocabularyTTL = time.Duration(envInt("SEARCH_VOCABULARY_TTL", 60)) * time.Second
+)
+
+// searchMaxExpansions caps the indexed words one query word expands to
+const searchMaxExpansions = 16
+
+// searchTranslit spells Russian and Uzbek Cyrillic letters in Uzbek Latin, so a word typed
+// in either script normalizes to the same text
+var searchTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "j",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "x", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sh", 'ъ': "", 'ы': "i", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'ў': "o", 'қ': "q", 'ғ': "g", 'ҳ': "h",
+	// Uzbek Latin writes o‘ and g‘ with one of several apostrophes
+	'\'': "", '‘': "", '’': "", 'ʻ': "", 'ʼ': "", '`': "",
+}
+
+// searchFold strips diacritics
+var searchFold = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// normalizeSearchText folds case and diacritics and transliterates Cyrillic to Latin
+func normalizeSearchText(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(norm.NFC.String(text)) {
+		if latin, ok := searchTranslit[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	folded, _, err := transform.String(searchFold, b.String())
+	if err != nil {
+		return b.String()
+	}
+	return folded
+}
+
+// levenshtein is the edit distance between a and b, counted in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			next := min(row[j]+1, row[j-1]+1, prev+cost)
+			prev, row[j] = row[j], next
+		}
+	}
+	return row[len(rb)]
+}
+
+// searchTypos is how many typos a normalized query word tolerates. Numbers have to match
+// exactly, so "1000" doesn't find "2000".
+func searchTypos(word string) int {
+	if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+		return 0
+	}
+	switch n := utf8.RuneCountInString(word); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// wordMatchScore tells how well an indexed word matches one of the normalized variants of
+// a query word: 0 for the same word, 1 when it starts with the variant, 2 and up for a
+// typo, or -1 when it doesn't match
+func wordMatchScore(word string, variants []string) int {
+	best := -1
+	for _, variant := range variants {
+		score := -1
+		switch {
+		case word == variant:
+			score = 0
+		case strings.HasPrefix(word, variant):
+			score = 1
+		default:
+			typos := searchTypos(variant)
+			diff := utf8.RuneCountInString(word) - utf8.RuneCountInString(variant)
+			if typos > 0 && diff <= typos && diff >= -typos {
+				if d := levenshtein(word, variant); d <= typos {
+					score = 1 + d
+				}
+			}
+		}
+		if score >= 0 && (best < 0 || score < best) {
+			best = score
+		}
+	}
+	return best
+}
+
+// searchVocabulary caches the words of the catalog, keyed by their normalized form, and
+// the synonym groups
+type searchVocabulary struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	words    map[string][]string // Normalized word: the forms it is indexed as
+	synonyms map[string][]string // Normalized word: the normalized words of its synonym group
+}
+
+var searchVocab = &searchVocabulary{}
+
+// invalidate makes the next query reload the vocabulary
+func (v *searchVocabulary) invalidate() {
+	v.mu.Lock()
+	v.loadedAt = time.Time{}
+	v.mu.Unlock()
+}
+
+// get returns the cached words and synonyms, reloading them when they are too old
+func (v *searchVocabulary) get() (map[string][]string, map[string][]string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.words == nil || time.Since(v.loadedAt) > searchVocabularyTTL {
+		words, synonyms, err := loadSearchVocabulary()
+		if err != nil {
+			log.Println("Failed to load search vocabulary:", err)
+			if v.words == nil {
+				return map[string][]string{}, map[string][]string{}
+			}
+		} else {
+			v.words, v.synonyms, v.loadedAt = words, synonyms, time.Now()
+		}
+	}
+	return v.words, v.synonyms
+}
+
+// loadSearchVocabulary reads the indexed words from the FTS5 vocabulary or, without FTS5,
+// splits the searched fields into words itself, keeping their case since SQLite's LIKE
+// ignores case only in ASCII
+func loadSearchVocabulary() (map[string][]string, map[string][]string, error) {
+	forms := map[string]bool{}
+	if db.FullTextSearch {
+		var terms []string
+		if err := db.DB.Raw("SELECT term FROM products_fts_vocab").Scan(&terms).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, term := range terms {
+			forms[term] = true
+		}
+	} else {
+		var texts []string
+		err := db.DB.Raw(`SELECT name || ' ' || description || ' ' || info || ' ' || feature FROM products
+			UNION SELECT name FROM categories UNION SELECT name FROM bottom_categories
+			UNION SELECT name FROM brands`).Scan(&texts).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, text := range texts {
+			for _, word := range splitWords(text) {
+				forms[word] = true
+			}
+		}
+	}
+
+	words := map[string][]string{}
+	for form := range forms {
+		key := normalizeSearchText(form)
+		words[key] = append(words[key], form)
+	}
+
+	var groups []models.SearchSynonym
+	if err := db.DB.Find(&groups).Error; err != nil {
+		return nil, nil, err
+	}
+	synonyms := map[string][]string{}
+	for _, group := range groups {
+		members := []string{normalizeSearchText(group.Term)}
+		for _, synonym := range group.Synonyms {
+			members = append(members, normalizeSearchText(synonym))
+		}
+		for _, member := range members {
+			synonyms[member] = append(synonyms[member], members...)
+		}
+	}
+	return words, synonyms, nil
+}
+
+// splitWords splits text into words the way the FTS5 unicode61 tokenizer does
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchVariants are the normalized forms a query word stands for: itself and its synonyms
+func searchVariants(term string, synonyms map[string][]string) []string {
+	normalized := normalizeSearchText(term)
+	variants := []string{normalized}
+	for _, synonym := range synonyms[normalized] {
+		if synonym != normalized {
+			variants = append(variants, synonym)
+		}
+	}
+	return variants
+}
+
+// searchTermVariants returns the normalized variants of each query word
+func searchTermVariants(terms []string) [][]string {
+	_, synonyms := searchVocab.get()
+	variants := make([][]string, len(terms))
+	for i, term := range terms {
+		variants[i] = searchVariants(term, synonyms)
+	}
+	return variants
+}
+
+// expandSearchTerms finds for each query word the indexed words it may mean, best matches
+// first. The query word itself always comes first, so a word indexed after the vocabulary
+// was loaded still matches.
+func expandSearchTerms(terms []string) [][]string {
+	words, synonyms := searchVocab.get()
+
+	expanded := make([][]string, len(terms))
+	for i, term := range terms {
+		variants := searchVariants(term, synonyms)
+
+		type candidate struct {
+			word  string
+			score int
+		}
+		var candidates []candidate
+		for word := range words {
+			if score := wordMatchScore(word, variants); score >= 0 {
+				candidates = append(candidates, candidate{word, score})
+			}
+		}
+		sort.Slice(candidates, func(a, b int) bool {
+			if candidates[a].score != candidates[b].score {
+				return candidates[a].score < candidates[b].score
+			}
+			return candidates[a].word < candidates[b].word
+		})
+
+		forms := []string{term}
+		seen := map[string]bool{term: true}
+		for _, candidate := range candidates {
+			for _, form := range words[candidate.word] {
+				if len(forms) == searchMaxExpansions {
+					break
+				}
+				if !seen[form] {
+					seen[form] = true
+					forms = append(forms, form)
+				}
+			}
+		}
+		expanded[i] = forms
+	}
+	return expanded
+}
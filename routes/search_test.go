@@ -0,0 +1,184 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// searchTestApp stores a small catalog and serves product search over it
+func searchTestApp(t *testing.T) (*fiber.App, map[string]uint) {
+	t.Helper()
+	openTestDB(t)
+	searchVocab.invalidate()
+	t.Cleanup(searchVocab.invalidate)
+
+	brand := models.Brand{Name: "Ресанта"}
+	if err := db.DB.Create(&brand).Error; err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]uint{}
+	for key, product := range map[string]models.Product{
+		"inverter":   {Name: "Сварочный инвертор САИ-250", Description: "Инвертор для ручной дуговой сварки", BrandID: brand.ID},
+		"electrodes": {Name: "Электроды ESAB OK 46.00", Description: "Электроды для сварки углеродистых сталей"},
+		"mask":       {Name: "Маска сварщика хамелеон", Description: "Автоматическое затемнение"},
+	} {
+		product.Price, product.Quantity = 1000, 10
+		if err := db.DB.Create(&product).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[key] = product.ID
+	}
+
+	app := fiber.New()
+	app.Get("/search", searchProducts)
+	return app, ids
+}
+
+func searchFor(t *testing.T, app *fiber.App, query string) SearchResponse {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", "/search?q="+url.QueryEscape(query), nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("search %q: status %d", query, resp.StatusCode)
+	}
+	var result SearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSearchProducts(t *testing.T) {
+	app, ids := searchTestApp(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"инвертор", []string{"inverter"}},
+		{"ИНВЕРТОР саи", []string{"inverter"}},
+		{"invertor", []string{"inverter"}},   // Latin spelling of a Cyrillic word
+		{"инвертр", []string{"inverter"}},    // Typo
+		{"elektrod", []string{"electrodes"}}, // Prefix in Latin
+		{"хамелеон маска", []string{"mask"}},
+		{"перфоратор", nil},
+	}
+	for _, tt := range tests {
+		result := searchFor(t, app, tt.query)
+		var got []uint
+		for _, product := range result.Products {
+			got = append(got, product.ID)
+		}
+		if len(got) != len(tt.want) || int(result.Total) != len(tt.want) {
+			t.Errorf("search %q found %v (total %d), want %v", tt.query, got, result.Total, tt.want)
+			continue
+		}
+		for i, key := range tt.want {
+			if got[i] != ids[key] {
+				t.Errorf("search %q found %v, want %v", tt.query, got, tt.want)
+			}
+		}
+	}
+
+	result := searchFor(t, app, "инвертор")
+	if len(result.Products) == 1 && !strings.Contains(result.Products[0].NameHighlight, searchMarkOpen+"инвертор"+searchMarkClose) {
+		t.Errorf("name highlight is %q", result.Products[0].NameHighlight)
+	}
+}
+
+// Highlights are HTML: the product text is escaped and only the marks are tags
+func TestSearchHighlightsAreEscaped(t *testing.T) {
+	app, _ := searchTestApp(t)
+	product := models.Product{
+		Name:        `Инвертор <img src=x onerror="alert(1)">`,
+		Description: `Горелка & кабель <b>инвертор</b>`,
+		Price:       1000, Quantity: 1,
+	}
+	if err := db.DB.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	searchVocab.invalidate()
+
+	result := searchFor(t, app, "onerror")
+	if len(result.Products) != 1 {
+		t.Fatalf("found %d products, want 1", len(result.Products))
+	}
+	hit := result.Products[0]
+	if strings.Contains(hit.NameHighlight, "<img") || !strings.Contains(hit.NameHighlight, "&lt;img") {
+		t.Errorf("name highlight is not escaped: %q", hit.NameHighlight)
+	}
+	if !strings.Contains(hit.NameHighlight, searchMarkOpen+"onerror"+searchMarkClose) {
+		t.Errorf("name highlight has no mark: %q", hit.NameHighlight)
+	}
+
+	result = searchFor(t, app, "горелка")
+	if len(result.Products) != 1 {
+		t.Fatalf("found %d products, want 1", len(result.Products))
+	}
+	if snippet := result.Products[0].Snippet; strings.Contains(snippet, "<b>") || !strings.Contains(snippet, "&amp;") ||
+		!strings.Contains(snippet, searchMarkOpen) {
+		t.Errorf("snippet is not escaped and marked: %q", snippet)
+	}
+}
+
+func TestHighlightTerms(t *testing.T) {
+	got := highlightTerms(`a<b & "инвертор"`, searchPattern([]string{"инвертор", "<b"}))
+	want := `a<mark>&lt;b</mark> &amp; &#34;<mark>инвертор</mark>&#34;`
+	if got != want {
+		t.Errorf("highlightTerms = %q, want %q", got, want)
+	}
+	if got := markHits("x<y " + searchHitOpen + "z" + searchHitClose); got != "x&lt;y <mark>z</mark>" {
+		t.Errorf("markHits = %q", got)
+	}
+}
+
+// Product edits reach the index without a restart
+func TestSearchFollowsProductChanges(t *testing.T) {
+	app, ids := searchTestApp(t)
+
+	if err := db.DB.Model(&models.Product{}).Where("id = ?", ids["mask"]).Update("name", "Щиток сварщика").Error; err != nil {
+		t.Fatal(err)
+	}
+	if result := searchFor(t, app, "щиток"); len(result.Products) != 1 || result.Products[0].ID != ids["mask"] {
+		t.Errorf("search for the new name found %+v", result.Products)
+	}
+
+	if err := db.DB.Delete(&models.Product{}, ids["electrodes"]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if result := searchFor(t, app, "ESAB"); len(result.Products) != 0 {
+		t.Errorf("search found the deleted product: %+v", result.Products)
+	}
+}
+
+func TestSuggestProducts(t *testing.T) {
+	app, ids := searchTestApp(t)
+	app.Get("/suggest", suggestProducts)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/suggest?q="+url.QueryEscape("свар инв"), nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Suggestions []Suggestion `json:"suggestions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	// Both words start a word of the name only for the inverter; the mask matches "свар" alone
+	if len(result.Suggestions) != 1 || result.Suggestions[0].ID != ids["inverter"] || result.Suggestions[0].Type != SuggestionProduct {
+		t.Errorf("suggestions are %+v", result.Suggestions)
+	}
+}
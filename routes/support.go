@@ -0,0 +1,441 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Support chat events, published on the conversation topic and the admins' support inbox
+const (
+	EventSupportMessage = "support.message"
+	EventSupportRead    = "support.read"
+)
+
+const supportMessageMaxLength = 4000
+
+// Guests open conversations without an account, so limit how fast one client can do that
+var supportConversationLimiter = newRateLimiter(envInt("SUPPORT_CONVERSATION_RATE_LIMIT", 5), time.Minute).handler
+
+// SupportConversationResponse is a conversation with the viewer's unread count. The guest
+// token is only returned once, when a guest opens the conversation.
+type SupportConversationResponse struct {
+	models.SupportConversation
+	Unread     int64  `json:"unread"`
+	GuestToken string `gorm:"-" json:"guest_token,omitempty"`
+}
+
+type SupportConversationRequest struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+type SupportMessageRequest struct {
+	Text string `json:"text"`
+}
+
+type SupportReadRequest struct {
+	MessageID uint `json:"message_id" validate:"required"`
+}
+
+// SupportReadEvent tells the other side how far a conversation has been read
+type SupportReadEvent struct {
+	ConversationID uint   `json:"conversation_id"`
+	Reader         string `json:"reader"` // customer or admin
+	LastReadID     uint   `json:"last_read_id"`
+}
+
+func supportTopic(conversationID uint) string {
+	return fmt.Sprintf("%s%d", topicSupportPrefix, conversationID)
+}
+
+// validateSupportText trims a chat message and checks its length
+func validateSupportText(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("Message text is required")
+	}
+	if len([]rune(text)) > supportMessageMaxLength {
+		return "", fmt.Errorf("Message must be at most %d characters", supportMessageMaxLength)
+	}
+	return text, nil
+}
+
+// postSupportMessage stores a message and pushes it to the conversation's subscribers.
+// Customer messages also go to the admins' support inbox. The sender has read everything
+// up to their own message.
+func postSupportMessage(conversation *models.SupportConversation, admin *models.Admin, text string) (*models.SupportMessage, error) {
+	message := models.SupportMessage{
+		ConversationID: conversation.ID,
+		Sender:         models.SupportSenderCustomer,
+		AuthorName:     conversation.Name,
+		Text:           text,
+	}
+	readColumn := "customer_last_read_id"
+	if admin != nil {
+		message.Sender = models.SupportSenderAdmin
+		message.AdminID = &admin.ID
+		message.AuthorName = admin.Name
+		readColumn = "admin_last_read_id"
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.SupportConversation{}).Where("id = ?", conversation.ID).Updates(map[string]interface{}{
+			"last_message_at": message.CreatedAt,
+			readColumn:        message.ID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publishEvent(supportTopic(conversation.ID), EventSupportMessage, message)
+	if message.Sender == models.SupportSenderCustomer {
+		publishEvent(topicSupportInbox, EventSupportMessage, message)
+	}
+	return &message, nil
+}
+
+// markSupportRead moves a side's read receipt forward to the message and tells the other
+// side. It never moves backwards and never past the last message. It returns the
+// resulting last read message ID.
+func markSupportRead(conversation *models.SupportConversation, reader string, messageID uint) (uint, error) {
+	column := "customer_last_read_id"
+	if reader == models.SupportSenderAdmin {
+		column = "admin_last_read_id"
+	}
+
+	var lastID uint
+	if err := db.DB.Model(&models.SupportMessage{}).
+		Where("conversation_id = ?", conversation.ID).
+		Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error; err != nil {
+		return 0, err
+	}
+	if messageID > lastID {
+		messageID = lastID
+	}
+
+	result := db.DB.Model(&models.SupportConversation{}).
+		Where("id = ? AND "+column+" < ?", conversation.ID, messageID).
+		Update(column, messageID)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Already read that far
+		var current models.SupportConversation
+		if err := db.DB.Select(column).First(&current, conversation.ID).Error; err != nil {
+			return 0, err
+		}
+		if reader == models.SupportSenderAdmin {
+			return current.AdminLastReadID, nil
+		}
+		return current.CustomerLastReadID, nil
+	}
+
+	event := SupportReadEvent{ConversationID: conversation.ID, Reader: reader, LastReadID: messageID}
+	publishEvent(supportTopic(conversation.ID), EventSupportRead, event)
+	if reader == models.SupportSenderAdmin {
+		publishEvent(topicSupportInbox, EventSupportRead, event)
+	}
+	return messageID, nil
+}
+
+// supportUnread counts the messages from the other side that the reader hasn't read yet
+func supportUnread(conversation *models.SupportConversation, reader string) int64 {
+	query := db.DB.Model(&models.SupportMessage{}).Where("conversation_id = ?", conversation.ID)
+	if reader == models.SupportSenderAdmin {
+		query = query.Where("sender = ? AND id > ?", models.SupportSenderCustomer, conversation.AdminLastReadID)
+	} else {
+		query = query.Where("sender = ? AND id > ?", models.SupportSenderAdmin, conversation.CustomerLastReadID)
+	}
+	var unread int64
+	if err := query.Count(&unread).Error; err != nil {
+		log.Printf("Failed to count unread support messages: %v", err)
+	}
+	return unread
+}
+
+// supportMessagesAfter returns up to limit messages newer than afterID, oldest first
+func supportMessagesAfter(conversationID, afterID uint, limit int) ([]models.SupportMessage, error) {
+	messages := []models.SupportMessage{}
+	err := db.DB.Where("conversation_id = ? AND id > ?", conversationID, afterID).
+		Order("id").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// supportConversation resolves the conversation a request is about and who is reading
+// it: the conversation in :id for admins, otherwise the signed-in customer's or the
+// guest's named by the X-Support-Token header
+func supportConversation(c *fiber.Ctx) (*models.SupportConversation, string, error) {
+	var conversation models.SupportConversation
+	var err error
+	switch {
+	case c.Params("id") != "":
+		err = db.DB.First(&conversation, c.Params("id")).Error
+		return &conversation, models.SupportSenderAdmin, err
+	case currentUser(c) != nil:
+		err = db.DB.Where("user_id = ?", currentUser(c).ID).First(&conversation).Error
+	case c.Get("X-Support-Token") != "":
+		err = db.DB.Where("guest_token = ?", c.Get("X-Support-Token")).First(&conversation).Error
+	default:
+		err = gorm.ErrRecordNotFound
+	}
+	return &conversation, models.SupportSenderCustomer, err
+}
+
+func supportNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Conversation not found",
+	})
+}
+
+// startSupportConversation - POST /support/conversation
+func startSupportConversation(c *fiber.Ctx) error {
+	// Signed-in customers have a single conversation that is reused
+	if user := currentUser(c); user != nil {
+		conversation := models.SupportConversation{UserID: &user.ID, Name: user.Name, Phone: user.Phone}
+		err := db.DB.Where("user_id = ?", user.ID).FirstOrCreate(&conversation).Error
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to open conversation",
+			})
+		}
+		return c.JSON(SupportConversationResponse{
+			SupportConversation: conversation,
+			Unread:              supportUnread(&conversation, models.SupportSenderCustomer),
+		})
+	}
+
+	var req SupportConversationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	fields := fiber.Map{}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		fields["name"] = "Name is required"
+	}
+	if digits := phoneDigits(req.Phone); len(digits) < 9 || len(digits) > 15 {
+		fields["phone"] = "Phone must contain 9 to 15 digits"
+	}
+	if len(fields) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fields,
+		})
+	}
+
+	conversation := models.SupportConversation{
+		GuestToken: uuid.New().String(),
+		Name:       req.Name,
+		Phone:      strings.TrimSpace(req.Phone),
+	}
+	if err := db.DB.Create(&conversation).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to open conversation",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(SupportConversationResponse{
+		SupportConversation: conversation,
+		GuestToken:          conversation.GuestToken,
+	})
+}
+
+// getSupportConversation - GET /support/conversation and GET /support/conversations/:id
+func getSupportConversation(c *fiber.Ctx) error {
+	conversation, reader, err := supportConversation(c)
+	if err != nil {
+		return supportNotFound(c)
+	}
+	return c.JSON(SupportConversationResponse{
+		SupportConversation: *conversation,
+		Unread:              supportUnread(conversation, reader),
+	})
+}
+
+// getSupportConversations - GET /support/conversations?unread=true
+func getSupportConversations(c *fiber.Ctx) error {
+	skip, limit, err := parsePaging(c, 20, 100)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	unreadCount := "(SELECT COUNT(*) FROM support_messages m WHERE m.conversation_id = support_conversations.id" +
+		" AND m.sender = '" + models.SupportSenderCustomer + "' AND m.id > support_conversations.admin_last_read_id)"
+	query := db.DB.Model(&models.SupportConversation{})
+	if c.QueryBool("unread") {
+		query = query.Where(unreadCount + " > 0")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count conversations",
+		})
+	}
+
+	conversations := []SupportConversationResponse{}
+	if err := query.Select("support_conversations.*, " + unreadCount + " AS unread").
+		Order("last_message_at IS NULL").Order("last_message_at DESC").Order("id DESC").
+		Offset(skip).Limit(limit).
+		Find(&conversations).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get conversations",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"conversations": conversations,
+		"total":         total,
+		"skip":          skip,
+		"limit":         limit,
+	})
+}
+
+// getSupportMessages - GET /support/conversation/messages and GET /support/conversations/:id/messages
+//
+// Pages back through history with before_id, or fetches what was missed with after_id.
+// Messages are returned oldest first.
+func getSupportMessages(c *fiber.Ctx) error {
+	conversation, _, err := supportConversation(c)
+	if err != nil {
+		return supportNotFound(c)
+	}
+
+	_, limit, err := parsePaging(c, 50, 200)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	beforeID := c.QueryInt("before_id", 0)
+	afterID := c.QueryInt("after_id", 0)
+	if beforeID < 0 || afterID < 0 || (beforeID > 0 && afterID > 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Use either before_id or after_id",
+		})
+	}
+
+	// One extra row tells whether there is more beyond this page
+	messages := []models.SupportMessage{}
+	if c.Query("after_id") != "" {
+		messages, err = supportMessagesAfter(conversation.ID, uint(afterID), limit+1)
+	} else {
+		query := db.DB.Where("conversation_id = ?", conversation.ID)
+		if beforeID > 0 {
+			query = query.Where("id < ?", beforeID)
+		}
+		err = query.Order("id DESC").Limit(limit + 1).Find(&messages).Error
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get messages",
+		})
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if c.Query("after_id") == "" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"messages":              messages,
+		"has_more":              hasMore,
+		"customer_last_read_id": conversation.CustomerLastReadID,
+		"admin_last_read_id":    conversation.AdminLastReadID,
+	})
+}
+
+// sendSupportMessage - POST /support/conversation/messages and POST /support/conversations/:id/messages
+func sendSupportMessage(c *fiber.Ctx) error {
+	conversation, reader, err := supportConversation(c)
+	if err != nil {
+		return supportNotFound(c)
+	}
+
+	var req SupportMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	text, err := validateSupportText(req.Text)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fiber.Map{"text": err.Error()},
+		})
+	}
+
+	var admin *models.Admin
+	if reader == models.SupportSenderAdmin {
+		admin = currentAdmin(c)
+	}
+	message, err := postSupportMessage(conversation, admin, text)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send message",
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(message)
+}
+
+// readSupportMessages - POST /support/conversation/read and POST /support/conversations/:id/read
+func readSupportMessages(c *fiber.Ctx) error {
+	conversation, reader, err := supportConversation(c)
+	if err != nil {
+		return supportNotFound(c)
+	}
+
+	var req SupportReadRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	if err := validate.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+
+	lastReadID, err := markSupportRead(conversation, reader, req.MessageID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to mark messages read",
+		})
+	}
+	var updated models.SupportConversation
+	if err := db.DB.First(&updated, conversation.ID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load conversation",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"last_read_id": lastReadID,
+		"unread":       supportUnread(&updated, reader),
+	})
+}
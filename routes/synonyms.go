@@ -0,0 +1,167 @@
+package routes
+
+import (
+	"strings"
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// SearchSynonymRequest creates or replaces a synonym group
+type SearchSynonymRequest struct {
+	Term     string   `json:"term" validate:"required"`
+	Synonyms []string `json:"synonyms" validate:"required,min=1,dive,required"`
+}
+
+// validateSearchSynonym checks that the term and every synonym are single words and that
+// no other group, except the one with id exceptID, has the same term once normalized
+func validateSearchSynonym(req *SearchSynonymRequest, exceptID uint) (fiber.Map, error) {
+	fields := fiber.Map{}
+	req.Term = strings.TrimSpace(req.Term)
+	if len(splitWords(req.Term)) != 1 {
+		fields["term"] = "Term must be a single word"
+	}
+	for i, synonym := range req.Synonyms {
+		req.Synonyms[i] = strings.TrimSpace(synonym)
+		if len(splitWords(synonym)) != 1 {
+			fields["synonyms"] = "Every synonym must be a single word"
+		}
+	}
+	if len(fields) > 0 {
+		return fields, nil
+	}
+
+	var groups []models.SearchSynonym
+	if err := db.DB.Select("id", "term").Where("id <> ?", exceptID).Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	term := normalizeSearchText(req.Term)
+	for _, group := range groups {
+		if normalizeSearchText(group.Term) == term {
+			fields["term"] = "Synonyms for this term already exist"
+			break
+		}
+	}
+	return fields, nil
+}
+
+// getSearchSynonyms - GET /search-synonyms
+func getSearchSynonyms(c *fiber.Ctx) error {
+	var synonyms []models.SearchSynonym
+	if err := db.DB.Order("term").Find(&synonyms).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get synonyms",
+		})
+	}
+	return c.JSON(fiber.Map{"synonyms": synonyms})
+}
+
+// createSearchSynonym - POST /search-synonyms
+func createSearchSynonym(c *fiber.Ctx) error {
+	var req SearchSynonymRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	if err := validate.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	fields, err := validateSearchSynonym(&req, 0)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check synonyms",
+		})
+	}
+	if len(fields) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fields,
+		})
+	}
+
+	synonym := models.SearchSynonym{Term: req.Term, Synonyms: req.Synonyms}
+	if err := db.DB.Create(&synonym).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create synonyms",
+		})
+	}
+	searchVocab.invalidate()
+
+	return c.Status(fiber.StatusCreated).JSON(synonym)
+}
+
+// updateSearchSynonym - PUT /search-synonyms/:id
+func updateSearchSynonym(c *fiber.Ctx) error {
+	var synonym models.SearchSynonym
+	if err := db.DB.First(&synonym, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Synonyms not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get synonyms",
+		})
+	}
+
+	var req SearchSynonymRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to parse request body",
+		})
+	}
+	if err := validate.Struct(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+	}
+	fields, err := validateSearchSynonym(&req, synonym.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check synonyms",
+		})
+	}
+	if len(fields) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"fields": fields,
+		})
+	}
+
+	synonym.Term, synonym.Synonyms = req.Term, req.Synonyms
+	if err := db.DB.Save(&synonym).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update synonyms",
+		})
+	}
+	searchVocab.invalidate()
+
+	return c.JSON(synonym)
+}
+
+// deleteSearchSynonym - DELETE /search-synonyms/:id
+func deleteSearchSynonym(c *fiber.Ctx) error {
+	result := db.DB.Delete(&models.SearchSynonym{}, c.Params("id"))
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete synonyms",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Synonyms not found",
+		})
+	}
+	searchVocab.invalidate()
+
+	return c.JSON(fiber.Map{
+		"message": "Synonyms deleted successfully",
+	})
+}
@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// TrackedOrder is the public view of an order: enough to follow its progress, nothing
+// that identifies the customer
+type TrackedOrder struct {
+	Number    string             `json:"number"`
+	Status    string             `json:"status"`
+	OrderType string             `json:"order_type"`
+	Service   string             `json:"service_mode"`
+	Price     float64            `json:"price"`
+	Items     []TrackedOrderItem `json:"items"`
+	Timeline  []TrackedStatus    `json:"timeline"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+type TrackedOrderItem struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+type TrackedStatus struct {
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// rateLimiter allows each client IP a fixed number of requests per window
+type rateLimiter struct {
+	max    int
+	window time.Duration
+	mutex  sync.Mutex
+	hits   map[string][]time.Time
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{max: max, window: window, hits: make(map[string][]time.Time)}
+}
+
+// allow records a request from the key and reports whether it is within the limit
+func (l *rateLimiter) allow(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	recent := l.hits[key][:0]
+	for _, hit := range l.hits[key] {
+		if now.Sub(hit) < l.window {
+			recent = append(recent, hit)
+		}
+	}
+	if len(recent) >= l.max {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+
+	// Forget clients that went quiet so the map doesn't grow forever
+	if len(l.hits) > 10000 {
+		for k, hits := range l.hits {
+			if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= l.window {
+				delete(l.hits, k)
+			}
+		}
+	}
+	return true
+}
+
+// handler rejects requests over the limit with 429
+func (l *rateLimiter) handler(c *fiber.Ctx) error {
+	if !l.allow(c.IP()) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many requests, try again later",
+		})
+	}
+	return c.Next()
+}
+
+// Lookups are keyed by number and phone, so limit how fast one client can guess them
+var trackOrderLimiter = newRateLimiter(envInt("TRACK_ORDER_RATE_LIMIT", 10), time.Minute).handler
+
+// phoneDigits strips everything but digits so "+998 90 123-45-67" matches "+998901234567"
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// trackOrder - GET /orders/track?number=&phone=
+func trackOrder(c *fiber.Ctx) error {
+	number := strings.ToUpper(strings.TrimSpace(c.Query("number")))
+	phone := phoneDigits(c.Query("phone"))
+	if number == "" || phone == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Order number and phone are required",
+		})
+	}
+
+	// The same answer for an unknown number and a wrong phone, so numbers can't be probed
+	notFound := func() error {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Order not found",
+		})
+	}
+
+	var order models.Order
+	if err := db.DB.
+		Preload("OrderItems.Product").
+		Preload("StatusHistory", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Where("number = ?", number).
+		First(&order).Error; err != nil {
+		return notFound()
+	}
+
+	// Legal orders carry no phone of their own; they are tracked with the account's phone
+	orderPhone := order.Phone
+	if orderPhone == "" && order.UserID != 0 {
+		var user models.User
+		if err := db.DB.Select("phone").First(&user, order.UserID).Error; err == nil {
+			orderPhone = user.Phone
+		}
+	}
+	if orderPhone == "" || phoneDigits(orderPhone) != phone {
+		return notFound()
+	}
+
+	tracked := TrackedOrder{
+		Number:    order.Number,
+		Status:    order.Status,
+		OrderType: order.OrderType,
+		Service:   order.Service,
+		Price:     order.Price,
+		Items:     []TrackedOrderItem{},
+		Timeline:  []TrackedStatus{},
+		CreatedAt: order.CreatedAt,
+		UpdatedAt: order.UpdatedAt,
+	}
+	for _, item := range order.OrderItems {
+		tracked.Items = append(tracked.Items, TrackedOrderItem{
+			Name:     item.Product.Name,
+			Quantity: item.Quantity,
+		})
+	}
+	for _, entry := range order.StatusHistory {
+		if entry.Event == models.OrderEventItems {
+			continue
+		}
+		tracked.Timeline = append(tracked.Timeline, TrackedStatus{
+			Status:    entry.ToStatus,
+			ChangedAt: entry.CreatedAt,
+		})
+	}
+
+	return c.JSON(tracked)
+}
@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"strings"
+	"time"
+
+	"weldmart/db"
+	"weldmart/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// OrderSummary is one row of a customer's order history
+type OrderSummary struct {
+	ID        uint      `json:"id"`
+	Number    string    `json:"number"`
+	Status    string    `json:"status"`
+	OrderType string    `json:"order_type"`
+	Service   string    `json:"service_mode"`
+	Price     float64   `json:"price"`
+	Bonus     float64   `json:"bonus"`
+	ItemCount int       `json:"item_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// historyUserID returns the customer whose orders are requested: the signed-in customer
+// on /me routes, the :id parameter otherwise
+func historyUserID(c *fiber.Ctx) (uint, bool) {
+	if c.Params("id") == "" {
+		if user := currentUser(c); user != nil {
+			return user.ID, true
+		}
+		return 0, false
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// getUserOrders - GET /users/:id/orders and GET /me/orders
+func getUserOrders(c *fiber.Ctx) error {
+	userID, ok := historyUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	skip, limit, err := parsePaging(c, 20, 100)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	dbQuery := db.DB.Model(&models.Order{}).Where("user_id = ?", userID)
+	if status := c.Query("status"); status != "" {
+		dbQuery = dbQuery.Where("status IN ?", strings.Split(status, ","))
+	}
+
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count orders",
+		})
+	}
+
+	var orders []models.Order
+	if err := dbQuery.Preload("OrderItems").
+		Order("created_at DESC").Order("id DESC").
+		Offset(skip).Limit(limit).
+		Find(&orders).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get orders",
+		})
+	}
+
+	summaries := []OrderSummary{}
+	for _, order := range orders {
+		summary := OrderSummary{
+			ID:        order.ID,
+			Number:    order.Number,
+			Status:    order.Status,
+			OrderType: order.OrderType,
+			Service:   order.Service,
+			Price:     order.Price,
+			Bonus:     order.Bonus,
+			CreatedAt: order.CreatedAt,
+			UpdatedAt: order.UpdatedAt,
+		}
+		for _, item := range order.OrderItems {
+			summary.ItemCount += item.Quantity
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return c.JSON(fiber.Map{
+		"orders": summaries,
+		"total":  total,
+		"skip":   skip,
+		"limit":  limit,
+	})
+}
+
+// getUserOrder - GET /users/:id/orders/:orderId and GET /me/orders/:orderId
+func getUserOrder(c *fiber.Ctx) error {
+	userID, ok := historyUserID(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	orderID, err := c.ParamsInt("orderId")
+	if err != nil || orderID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	var order models.Order
+	if err := db.DB.Preload("OrderItems.Product").
+		Preload("StatusHistory", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Where("id = ? AND user_id = ?", orderID, userID).
+		First(&order).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Order not found",
+		})
+	}
+
+	// Admins see the full history; customers only see when the status changed, like on
+	// the tracking page, without who changed it or the staff notes
+	if currentAdmin(c) == nil {
+		order.StatusHistory = customerStatusHistory(order.StatusHistory)
+	}
+
+	return c.JSON(newOrderResponse(order))
+}
+
+// customerStatusHistory keeps the status changes of an order and drops item edits, the
+// staff who made them and their notes
+func customerStatusHistory(history []models.OrderStatusHistory) []models.OrderStatusHistory {
+	visible := []models.OrderStatusHistory{}
+	for _, entry := range history {
+		if entry.Event == models.OrderEventItems {
+			continue
+		}
+		visible = append(visible, models.OrderStatusHistory{
+			ID:         entry.ID,
+			OrderID:    entry.OrderID,
+			Event:      entry.Event,
+			FromStatus: entry.FromStatus,
+			ToStatus:   entry.ToStatus,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+	return visible
+}
@@ -0,0 +1,125 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+)
+
+type sheetXML struct {
+	Rows []struct {
+		Ref   string `xml:"r,attr"`
+		Cells []struct {
+			Ref     string  `xml:"r,attr"`
+			Type    string  `xml:"t,attr"`
+			Style   string  `xml:"s,attr"`
+			Value   string  `xml:"v"`
+			Text    string  `xml:"is>t"`
+			Formula *string `xml:"f"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readWorkbook opens the zip and returns its parts by name
+func readWorkbook(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal("not a zip:", err)
+	}
+	parts := make(map[string][]byte)
+	for _, file := range archive.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[file.Name] = body
+	}
+	return parts
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "Orders & Co")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteHeader([]string{"id", "name", "price", "created_at"}); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rows := [][]interface{}{
+		{uint(7), "Сварка <ООО>", 1250.5, created},
+		{-3, "=HYPERLINK(\"http://example.com\")", nil, ""},
+		{1, "+998901234567", "@SUM(A1)", "\tfield"},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := readWorkbook(t, buf.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/workbook.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook has no %s", name)
+		}
+		if err := xml.Unmarshal(parts[name], new(interface{})); err != nil {
+			t.Errorf("%s is not well-formed XML: %v", name, err)
+		}
+	}
+	if !bytes.Contains(parts["xl/workbook.xml"], []byte(`name="Orders &amp; Co"`)) {
+		t.Errorf("sheet name is not escaped: %s", parts["xl/workbook.xml"])
+	}
+
+	var sheet sheetXML
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+	type cell struct{ ref, typ, style, value, text string }
+	want := [][]cell{
+		{{"A1", "inlineStr", "1", "", "id"}, {"B1", "inlineStr", "1", "", "name"}, {"C1", "inlineStr", "1", "", "price"}, {"D1", "inlineStr", "1", "", "created_at"}},
+		{{"A2", "", "", "7", ""}, {"B2", "inlineStr", "", "", "Сварка <ООО>"}, {"C2", "", "", "1250.5", ""}, {"D2", "", "2", "45352.500000", ""}},
+		{{"A3", "", "", "-3", ""}, {"B3", "inlineStr", "", "", "=HYPERLINK(\"http://example.com\")"}},
+		{{"A4", "", "", "1", ""}, {"B4", "inlineStr", "", "", "+998901234567"}, {"C4", "inlineStr", "", "", "@SUM(A1)"}, {"D4", "inlineStr", "", "", "\tfield"}},
+	}
+	if len(sheet.Rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(sheet.Rows), len(want))
+	}
+	for i, row := range sheet.Rows {
+		var got []cell
+		for _, c := range row.Cells {
+			if c.Formula != nil {
+				t.Errorf("cell %s is a formula", c.Ref)
+			}
+			got = append(got, cell{c.Ref, c.Type, c.Style, c.Value, c.Text})
+		}
+		if len(got) != len(want[i]) {
+			t.Fatalf("row %d: got %+v, want %+v", i+1, got, want[i])
+		}
+		for j := range got {
+			if got[j] != want[i][j] {
+				t.Errorf("row %d: got %+v, want %+v", i+1, got[j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for index, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(index); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", index, got, want)
+		}
+	}
+}